@@ -0,0 +1,61 @@
+package passkey
+
+import (
+	"net/http"
+	"time"
+)
+
+/*
+
+	AUDIT
+	a structured record of every authentication decision made by the
+	middleware for a compliance trail shipped to a SIEM; distinct from
+	the Logger, which is for operators
+
+	pk.Audit(func(e passkey.AuditEvent) { events <- e })
+
+	the sink is called synchronously on the request goroutine before the
+	response is written, so it must not block; hand the event to a
+	buffered channel or an asynchronous shipper
+
+*/
+
+// AuditEvent is the record of one authentication decision
+type AuditEvent struct {
+	Time    time.Time `json:"time"`            // decision time on the server clock
+	Remote  string    `json:"remote"`          // request RemoteAddr
+	Method  string    `json:"method"`          // request method
+	Path    string    `json:"path"`            // request URL path
+	Outcome string    `json:"outcome"`         // authorized, rejected, fallback, or would-reject
+	Window  Window    `json:"window"`          // matched window when authorized
+	Error   string    `json:"error,omitempty"` // verification error when not authorized
+}
+
+// Audit sets the sink called with an AuditEvent for every request the
+// middleware authorizes, rejects, passes to the OnReject fallback, or would
+// reject in DryRun mode; the sink runs synchronously on the request
+// goroutine so it must not block; no work is done when unset
+//
+//	pass nil to disable; default
+func (pk *Server) Audit(sink func(AuditEvent)) *Server {
+	pk.audit = sink
+	return pk
+}
+
+// record calls the audit sink with the decision for the request
+func (pk *Server) record(r *http.Request, outcome string, window Window, err error) {
+
+	e := AuditEvent{
+		Time:    pk.now(),
+		Remote:  r.RemoteAddr,
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Outcome: outcome,
+		Window:  window,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+
+	pk.audit(e)
+}
@@ -0,0 +1,149 @@
+package passkey
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// discard is a reusable http.ResponseWriter so a benchmark measures the
+// middleware rather than the recorder
+type discard struct{ h http.Header }
+
+func (d *discard) Header() http.Header         { return d.h }
+func (d *discard) Write(b []byte) (int, error) { return len(b), nil }
+func (d *discard) WriteHeader(int)             {}
+
+// BenchmarkIsValid measures the middleware for a valid token, a stale token
+// of an expired window, and a malformed token
+func BenchmarkIsValid(b *testing.B) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pk := NewServer(ctx, rfcSecret20)
+	handler := pk.IsValid(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	client := new(Client)
+	client.Secret(rfcSecret20)
+	client.Start(ctx)
+	stale := new(Client)
+	stale.Secret(rfcSecret20)
+	stale.Clock(func() time.Time { return time.Now().Add(-10 * time.Minute) })
+	stale.Start(ctx)
+
+	for _, tc := range []struct {
+		name, token string
+	}{
+		{"valid", client.Token()},
+		{"stale", stale.Token()},
+		{"malformed", "AAAAAAAAAAAAAAA!"},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Token", tc.token)
+			w := &discard{h: make(http.Header)}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				handler.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// benchConfigs are the default skew and a wide skew of five windows each way
+var benchConfigs = []struct {
+	name         string
+	past, future int
+}{
+	{"default", 1, 1},
+	{"wide", 5, 5},
+}
+
+// benchServer returns a started server with the skew of the config
+func benchServer(ctx context.Context, past, future int) *Server {
+	pk := new(Server)
+	pk.Secret(rfcSecret20)
+	pk.PastSkew(past).FutureSkew(future)
+	pk.Start(ctx)
+	return pk
+}
+
+func BenchmarkVerify(b *testing.B) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := NewClient(ctx, rfcSecret20)
+	other := NewClient(ctx, rfcSecret32)
+
+	for _, c := range benchConfigs {
+		pk := benchServer(ctx, c.past, c.future)
+		for _, tc := range []struct {
+			name, token string
+		}{
+			{"valid", client.Token()},
+			{"invalid", other.Token()},
+		} {
+			b.Run(c.name+"/"+tc.name, func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					pk.Verify(tc.token)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkGenerate(b *testing.B) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, c := range benchConfigs {
+		pk := benchServer(ctx, c.past, c.future)
+		b.Run(c.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				pk.regenerate()
+			}
+		})
+	}
+}
+
+func BenchmarkSetHeader(b *testing.B) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := NewClient(ctx, rfcSecret20)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		client.SetHeader(r)
+	}
+}
+
+func BenchmarkMiddleware(b *testing.B) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := NewClient(ctx, rfcSecret20)
+	for _, c := range benchConfigs {
+		pk := benchServer(ctx, c.past, c.future)
+		handler := pk.IsValid(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		client.SetHeader(r)
+		w := &discard{h: make(http.Header)}
+		b.Run(c.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				handler.ServeHTTP(w, r)
+			}
+		})
+	}
+}
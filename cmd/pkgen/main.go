@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/zxdev/passkey"
+)
+
+/*
+
+	pkgen
+	is a helper utility that will generate random shared sercrets
+	as well a valid passkey codes based off of a valid base32
+	encoded 32-character shared secret which can be utilized for
+	command line testing or single interaction use cases; specify
+	unique intervals in seconds or the default time.Minute is used
+
+	% pkgen
+	LMK3UEETD52M4EHZWAQ3CJHZ37OI3GQA
+
+	% pkgen LMK3UEETD52M4EHZWAQ3CJHZ37OI3GQA
+	GM3RCIQWPCJL4YAS
+
+	% pkgen LMK3UEETD52M4EHZWAQ3CJHZ37OI3GQA 15
+	ZOGFKOQPDOG5TI5S
+
+	% curl -H token:$(pkgen AW6TJVTYMAYJXLWFW2WWJ6D3Q5B2AY25) http://localhost:8080/hello
+
+	% pkgen -secret LMK3UEETD52M4EHZWAQ3CJHZ37OI3GQA -interval 15 -all -json
+	{"current":"ZOGFKOQPDOG5TI5S","next":"...","previous":"..."}
+
+	% pkgen -secret LMK3UEETD52M4EHZWAQ3CJHZ37OI3GQA -verify ZOGFKOQPDOG5TI5S
+	true
+
+	% pkgen init
+	LMK3UEETD52M4EHZWAQ3CJHZ37OI3GQA
+
+	% pkgen
+	GM3RCIQWPCJL4YAS
+
+	the init subcommand persists a new secret to ~/.pkgen which is
+	used when no secret is supplied; -force overwrites an existing
+
+	% pkgen serve -socket /run/pkgen.sock &
+	% echo | nc -U /run/pkgen.sock
+	GM3RCIQWPCJL4YAS
+
+	the serve subcommand runs the interval generator and writes the
+	current token to each connection on the 0600 unix socket until
+	interrupted
+
+	install pkgen on your machine
+	go install github.com/zxdev/passkey/cmd/pkgen@latest
+*/
+
+func main() {
+
+	// persist a new secret
+	if len(os.Args) > 1 && os.Args[1] == "init" {
+		initSecret(os.Args[2:])
+		return
+	}
+
+	// serve tokens on a unix socket
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		serve(os.Args[2:])
+		return
+	}
+
+	var (
+		secret   = flag.String("secret", os.Getenv("SECRET"), "base32 `secret`; default $SECRET or ~/.pkgen")
+		seconds  = flag.Int("interval", envInt("INTERVAL"), "interval in `seconds`; default $INTERVAL or 60")
+		asJSON   = flag.Bool("json", false, "emit JSON")
+		verify   = flag.String("verify", "", "verify the `token` against the secret; exits 1 when invalid")
+		watch    = flag.Bool("watch", false, "emit each new token until interrupted")
+		all      = flag.Bool("all", false, "emit the previous, current, and next tokens")
+		shimHelp = len(os.Args) > 1 && strings.TrimLeft(os.Args[1], "-") == "help"
+	)
+	flag.Usage = usage
+	if shimHelp {
+		usage()
+		return
+	}
+	flag.Parse()
+
+	// positional compatibility; pkgen {secret} {seconds}
+	for _, arg := range flag.Args() {
+		if strings.HasPrefix(arg, "-") {
+			fmt.Fprintln(os.Stderr, "pkgen: flag", arg, "after {secret}; flags must come first, see pkgen -h")
+			os.Exit(1)
+		}
+	}
+	if args := flag.Args(); len(args) > 0 {
+		if len(*secret) == 0 {
+			*secret = args[0]
+		}
+		if *seconds == 0 && len(args) > 1 {
+			*seconds, _ = strconv.Atoi(args[1])
+		}
+	}
+	if len(*secret) == 0 {
+		if b, err := os.ReadFile(secretPath()); err == nil {
+			*secret = string(b)
+		}
+	}
+	interval := time.Duration(*seconds) * time.Second
+	if interval <= 0 {
+		interval = time.Minute // -all and -watch step by the interval
+	}
+
+	// configure passkey.CMD using the secret and interval and when
+	// none are supplied a random secret will be generated; only a bare
+	// pkgen emits it since a token for a secret no server could know is
+	// never valid
+	pk := new(passkey.CMD)
+	pk.Interval(&interval)
+	current := pk.Current(*secret)
+	if pk.SecretGenerated() {
+		if len(*secret) > 0 || len(*verify) > 0 || *all || *watch {
+			fmt.Fprintln(os.Stderr, "pkgen: no valid secret; see pkgen -h")
+			os.Exit(1)
+		}
+		emit(*asJSON, map[string]interface{}{"secret": pk.Show()}, pk.Show())
+		return
+	}
+
+	switch {
+	case len(*verify) > 0:
+		srv := new(passkey.Server)
+		srv.Interval(&interval)
+		if srv.Secret(*secret) == nil || srv.Fingerprint() == "none" {
+			fmt.Fprintln(os.Stderr, "pkgen: no valid secret; see pkgen -h")
+			os.Exit(1)
+		}
+		valid := srv.VerifyAt(*verify, time.Now())
+		emit(*asJSON, map[string]interface{}{"token": *verify, "valid": valid}, strconv.FormatBool(valid))
+		if !valid {
+			os.Exit(1)
+		}
+
+	case *all:
+		now := time.Now()
+		tokens := make([]string, 3)
+		for i, d := range []time.Duration{-interval, 0, interval} {
+			pk.Clock(func() time.Time { return now.Add(d) })
+			tokens[i] = pk.Current(*secret)
+		}
+		emit(*asJSON, map[string]interface{}{"previous": tokens[0], "current": tokens[1], "next": tokens[2]},
+			strings.Join(tokens, "\n"))
+
+	case *watch:
+		poll := time.Second
+		if interval < 10*time.Second {
+			poll = interval / 10
+		}
+		for last := uint64(0); ; time.Sleep(poll) {
+			if index := pk.CurrentIndex(); index != last {
+				current = pk.Current(*secret)
+				emit(*asJSON, map[string]interface{}{"token": current, "index": index}, current)
+				last = index
+			}
+		}
+
+	default:
+		emit(*asJSON, map[string]interface{}{"token": current, "index": pk.CurrentIndex()}, current)
+	}
+}
+
+// usage writes the command usage and flag help
+func usage() {
+	w := flag.CommandLine.Output()
+	fmt.Fprintln(w, "usage: pkgen [flags]                            | emits {secret} or token with ~/.pkgen")
+	fmt.Fprintln(w, "usage: pkgen [flags] {secret} {seconds}         | emits token")
+	fmt.Fprintln(w, "usage: SECRET={secret} INTERVAL={seconds} pkgen | emits token")
+	fmt.Fprintln(w, "usage: pkgen init [-force]                      | persists {secret} to ~/.pkgen")
+	fmt.Fprintln(w, "usage: pkgen serve [-socket path] [flags]       | serves tokens on a unix socket")
+	flag.PrintDefaults()
+}
+
+// emit writes v as JSON or the text line
+func emit(asJSON bool, v interface{}, text string) {
+	if asJSON {
+		json.NewEncoder(os.Stdout).Encode(v)
+		return
+	}
+	fmt.Fprintln(os.Stdout, text)
+}
+
+// envInt returns the integer value of the environment variable or 0
+func envInt(name string) int {
+	i, _ := strconv.Atoi(os.Getenv(name))
+	return i
+}
+
+// secretPath returns the path of the persisted secret; ~/.pkgen
+func secretPath() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".pkgen")
+}
+
+// initSecret generates a new secret and persists it to ~/.pkgen with 0600
+// permissions; an existing secret is only overwritten when forced
+func initSecret(args []string) {
+
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	force := fs.Bool("force", false, "overwrite an existing secret")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "pkgen: unexpected argument", fs.Arg(0), "to init; see pkgen init -h")
+		os.Exit(1)
+	}
+
+	path := secretPath()
+	if _, err := os.Stat(path); err == nil && !*force {
+		fmt.Fprintln(os.Stderr, "pkgen:", path, "exists; use init -force to overwrite")
+		os.Exit(1)
+	}
+
+	secret, err := passkey.GenerateSecret(0)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "pkgen:", err)
+		os.Exit(1)
+	}
+
+	if err := os.WriteFile(path, []byte(secret+"\n"), 0600); err != nil {
+		fmt.Fprintln(os.Stderr, "pkgen:", err)
+		os.Exit(1)
+	}
+	os.Chmod(path, 0600) // WriteFile only applies perm on create
+
+	// warn loudly when others can reach the secret
+	if fi, err := os.Stat(filepath.Dir(path)); err == nil && fi.Mode().Perm()&0077 != 0 {
+		fmt.Fprintf(os.Stderr, "pkgen: WARNING %s is group/world accessible (%s)\n", filepath.Dir(path), fi.Mode().Perm())
+	}
+
+	fmt.Fprintln(os.Stdout, secret)
+}
+
+// serve runs the interval generator and writes the current token to each
+// connection on a 0600 unix socket until interrupted
+func serve(args []string) {
+
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	socket := fs.String("socket", "/run/pkgen.sock", "unix socket `path`")
+	secret := fs.String("secret", os.Getenv("SECRET"), "base32 `secret`; default $SECRET or ~/.pkgen")
+	seconds := fs.Int("interval", envInt("INTERVAL"), "interval in `seconds`; default $INTERVAL or 60")
+	fs.Parse(args)
+
+	if len(*secret) == 0 {
+		if b, err := os.ReadFile(secretPath()); err == nil {
+			*secret = string(b)
+		}
+	}
+
+	// never serve tokens for a generated secret no server could know
+	interval := time.Duration(*seconds) * time.Second
+	pk := new(passkey.CMD)
+	pk.Interval(&interval)
+	pk.Current(*secret)
+	if pk.SecretGenerated() {
+		fmt.Fprintln(os.Stderr, "pkgen: no valid secret; see pkgen -h")
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	pk.Start(ctx)
+	defer pk.Close()
+
+	// bind in a private 0700 directory and move the 0600 socket into place
+	// so it is never reachable with umask permissions
+	dir, err := os.MkdirTemp(filepath.Dir(*socket), ".pkgen-")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "pkgen:", err)
+		os.Exit(1)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "sock")
+	ln, err := net.Listen("unix", path)
+	if err == nil {
+		ln.(*net.UnixListener).SetUnlinkOnClose(false)
+		if err = os.Chmod(path, 0600); err == nil {
+			err = removeSocket(*socket) // stale socket of a prior run
+		}
+		if err == nil {
+			err = os.Rename(path, *socket)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "pkgen:", err)
+		os.RemoveAll(dir)
+		os.Exit(1)
+	}
+	defer os.Remove(*socket)
+
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return // closed on interrupt
+		}
+		fmt.Fprintln(conn, pk.Token())
+		conn.Close()
+	}
+}
+
+// removeSocket removes a stale socket at path and refuses to replace any
+// other kind of file
+func removeSocket(path string) error {
+	fi, err := os.Lstat(path)
+	switch {
+	case os.IsNotExist(err):
+		return nil
+	case err != nil:
+		return err
+	case fi.Mode()&os.ModeSocket == 0:
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	return os.Remove(path)
+}
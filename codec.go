@@ -0,0 +1,74 @@
+package passkey
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+/*
+
+	CODEC
+	the token wire format shared by client and server; base32 is the
+	default and the others trade the base32 alphabet for a shorter or
+	more familiar form
+
+	pk.Codec(passkey.Base64URL)
+
+	base32     16 characters; [A..Z,2..7] with = padding
+	base64url  14 characters; [A..Z,a..z,0..9,-,_] unpadded
+	hex        20 characters; [0..9,a..f]
+
+	a codec replaces only the text form of the decoded token, the 8-byte
+	token value and the obfuscation bytes, so the length, strict, and
+	stamp checks apply unchanged; TOTP codes are always decimal
+
+*/
+
+// TokenCodec encodes the decoded token to its wire form and back
+type TokenCodec interface {
+	Encode(b []byte) string
+	Decode(s string) ([]byte, error)
+}
+
+var (
+	// Base32 is the default padded base32 codec
+	Base32 TokenCodec = base32Codec{}
+	// Base64URL is the unpadded URL safe base64 codec
+	Base64URL TokenCodec = base64URLCodec{}
+	// Hex is the lowercase hexadecimal codec; decoding accepts either case
+	Hex TokenCodec = hexCodec{}
+)
+
+// Codec sets the token wire format; client and server must agree; Base32
+// uses the built-in base32 path with TokenNoPadding and CaseInsensitive
+//
+//	pass nil for Base32; default
+func (pk *PassKey) Codec(codec TokenCodec) *PassKey {
+	if codec == Base32 {
+		codec = nil
+	}
+	pk.codec = codec
+	return pk
+}
+
+// base32Codec is the padded base32 TokenCodec
+type base32Codec struct{}
+
+func (base32Codec) Encode(b []byte) string { return base32.StdEncoding.EncodeToString(b) }
+
+func (base32Codec) Decode(s string) ([]byte, error) { return base32.StdEncoding.DecodeString(s) }
+
+// base64URLCodec is the unpadded URL safe base64 TokenCodec
+type base64URLCodec struct{}
+
+func (base64URLCodec) Encode(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+func (base64URLCodec) Decode(s string) ([]byte, error) { return base64.RawURLEncoding.DecodeString(s) }
+
+// hexCodec is the hexadecimal TokenCodec
+type hexCodec struct{}
+
+func (hexCodec) Encode(b []byte) string { return hex.EncodeToString(b) }
+
+func (hexCodec) Decode(s string) ([]byte, error) { return hex.DecodeString(s) }
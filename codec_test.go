@@ -0,0 +1,59 @@
+package passkey
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, tc := range []struct {
+		name  string
+		codec TokenCodec
+		size  int
+	}{
+		{"base32", Base32, 16},
+		{"base64url", Base64URL, 14},
+		{"hex", Hex, 20},
+	} {
+		b := []byte{0, 1, 2, 0xfe, 0xff, 0x80, 0x7f, 0x10, 0x20, 0x30}
+		if got, err := tc.codec.Decode(tc.codec.Encode(b)); err != nil || string(got) != string(b) {
+			t.Errorf("%s: round trip %x: %x %v", tc.name, b, got, err)
+		}
+
+		pk := NewServer(ctx, rfcSecret20)
+		pk.Codec(tc.codec)
+		client := NewClient(ctx, rfcSecret20)
+		client.Codec(tc.codec)
+
+		token := client.Token()
+		if len(token) != tc.size {
+			t.Errorf("%s: token %q length %d want %d", tc.name, token, len(token), tc.size)
+		}
+		if _, err := pk.Verify(token); err != nil {
+			t.Errorf("%s: verify %q: %v", tc.name, token, err)
+		}
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		client.SetHeader(r)
+		if code := serve(pk, r); code != http.StatusOK {
+			t.Errorf("%s: status %d", tc.name, code)
+		}
+
+		// a token of another codec is malformed
+		other := NewClient(ctx, rfcSecret20)
+		if tc.codec == Hex {
+			other.Codec(Base64URL)
+		} else {
+			other.Codec(Hex)
+		}
+		if _, err := pk.Verify(other.Token()); err == nil {
+			t.Errorf("%s: accepted %q", tc.name, other.Token())
+		}
+	}
+}
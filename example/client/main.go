@@ -30,7 +30,10 @@ func main() {
 	var pk passkey.Client
 	pk.Secret("PASSKEYXXBASE32XXSECRETXXEXAMPLE")
 	pk.Interval(&interval)
-	pk.Start(context.Background())
+	if err := pk.Start(context.Background()); err != nil {
+		log.Println(err)
+		return
+	}
 
 	var exit = 15
 	for exit != 0 {
@@ -0,0 +1,115 @@
+package mnemonic
+
+import (
+	"crypto/sha256"
+	"errors"
+	"strings"
+)
+
+/*
+
+	MNEMONIC
+	maps a binary secret to and from a human friendly word list
+	with one word per byte followed by a checksum word so that an
+	operator can read a secret aloud or transcribe it without the
+	base32 typos; words are case insensitive
+
+	{word per secret byte} {checksum word}
+
+*/
+
+var (
+	// ErrWord is returned when a word is not in the word list
+	ErrWord = errors.New("mnemonic: unknown word")
+	// ErrChecksum is returned when the checksum word does not match
+	ErrChecksum = errors.New("mnemonic: checksum mismatch")
+)
+
+// Encode returns the mnemonic for the secret; each byte maps to a word
+// and a checksum word derived from the secret is appended
+func Encode(secret []byte) string {
+
+	words := make([]string, 0, len(secret)+1)
+	for _, b := range secret {
+		words = append(words, list[b])
+	}
+	words = append(words, list[checksum(secret)])
+
+	return strings.Join(words, " ")
+}
+
+// Decode returns the secret for the mnemonic; the words may be separated
+// by any whitespace and the checksum word must match
+func Decode(words string) ([]byte, error) {
+
+	fields := strings.Fields(strings.ToLower(words))
+	if len(fields) < 2 {
+		return nil, ErrChecksum
+	}
+
+	secret := make([]byte, 0, len(fields)-1)
+	for _, word := range fields {
+		b, ok := index[word]
+		if !ok {
+			return nil, ErrWord
+		}
+		secret = append(secret, b)
+	}
+
+	if secret[len(secret)-1] != checksum(secret[:len(secret)-1]) {
+		return nil, ErrChecksum
+	}
+
+	return secret[:len(secret)-1], nil
+}
+
+// checksum returns the first byte of the sha256 sum of the secret
+func checksum(secret []byte) byte {
+	sum := sha256.Sum256(secret)
+	return sum[0]
+}
+
+// index maps a word to its byte value
+var index = func() map[string]byte {
+	m := make(map[string]byte, len(list))
+	for i, word := range list {
+		m[word] = byte(i)
+	}
+	return m
+}()
+
+// list of 256 words; one for each byte value, each with a unique four letter prefix
+var list = [256]string{
+	"acid", "acre", "act", "aim", "air", "alarm", "alert", "alley",
+	"alpha", "angle", "ankle", "apple", "arch", "arena", "arm", "arrow",
+	"art", "atom", "auto", "award", "axis", "bacon", "badge", "bag",
+	"ball", "bamboo", "band", "bank", "basil", "beach", "beam", "bear",
+	"bell", "belt", "berry", "bike", "bird", "blank", "blue", "boat",
+	"bolt", "bone", "book", "box", "brain", "bread", "bridge", "cabin",
+	"cable", "camel", "camp", "candy", "canoe", "card", "cargo", "carpet",
+	"castle", "cat", "cave", "chair", "chalk", "cheese", "chess", "chief",
+	"chip", "city", "claw", "clay", "clock", "cloud", "coast", "cobra",
+	"coin", "comet", "corn", "couch", "crab", "crane", "cube", "cup",
+	"daisy", "delta", "desk", "dial", "dice", "dingo", "dish", "dog",
+	"doll", "dolphin", "door", "dove", "dragon", "duck", "dune", "eagle",
+	"echo", "edge", "egg", "elk", "ember", "empire", "engine", "epic",
+	"exit", "fabric", "farm", "feather", "fence", "ferry", "field", "fig",
+	"finch", "fire", "fish", "flame", "flute", "foam", "forest", "fork",
+	"fox", "fruit", "gadget", "galaxy", "garlic", "gate", "gear", "gem",
+	"giant", "ginger", "glass", "glove", "goat", "gold", "goose", "grape",
+	"grass", "habit", "hammer", "harbor", "hat", "hawk", "hazel", "hedge",
+	"helmet", "hero", "hive", "honey", "hood", "hotel", "house", "ice",
+	"igloo", "image", "index", "ink", "island", "ivory", "jacket", "jaguar",
+	"jam", "jar", "jeans", "jelly", "jet", "judge", "juice", "jungle",
+	"kettle", "key", "king", "kiwi", "knife", "knot", "ladder", "lake",
+	"lamp", "laser", "lava", "lawn", "leaf", "lens", "lily", "lime",
+	"lizard", "llama", "lobster", "lotus", "magnet", "mango", "marble", "mask",
+	"meadow", "metal", "mint", "mirror", "moose", "moss", "motor", "mule",
+	"nail", "napkin", "nest", "net", "night", "noble", "north", "nut",
+	"oak", "ocean", "olive", "onion", "orange", "orbit", "otter", "paddle",
+	"panda", "paper", "peach", "pearl", "pencil", "piano", "pilot", "pine",
+	"planet", "plum", "pony", "quartz", "queen", "quill", "rabbit", "radio",
+	"rain", "ranch", "reef", "rice", "ring", "robin", "rocket", "rose",
+	"saddle", "salmon", "sand", "seal", "shell", "ship", "skate", "sled",
+	"snail", "sofa", "spider", "table", "tomato", "tower", "train", "tulip",
+}
@@ -0,0 +1,79 @@
+package ntp
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+)
+
+/*
+
+	NTP
+	minimal SNTP client that queries an NTP server and returns the
+	local clock offset for use with passkey.Client.Offset; kept apart
+	from the passkey package so the core has no network dependency
+
+	offset, err := ntp.Offset("pool.ntp.org")
+	if err == nil {
+		pk.Offset(offset)
+	}
+
+*/
+
+// Timeout is the maximum time Offset waits for the NTP server
+const Timeout = time.Second * 5
+
+// ErrResponse is returned when the NTP server response is invalid
+var ErrResponse = errors.New("ntp: invalid response")
+
+// ntpEpoch is the offset between the NTP era 0 epoch of 1900 and the unix epoch
+const ntpEpoch = 2208988800
+
+// Offset queries the NTP server and returns the local clock offset; a positive
+// offset means the local clock is behind the server; the default port 123 is
+// used when the server has no port and the query fails after Timeout
+func Offset(server string) (time.Duration, error) {
+
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "123")
+	}
+
+	conn, err := net.DialTimeout("udp", server, Timeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(Timeout))
+
+	// LI 0, VN 4, Mode 3 client request
+	var req [48]byte
+	req[0] = 0x23
+
+	t1 := time.Now()
+	if _, err := conn.Write(req[:]); err != nil {
+		return 0, err
+	}
+
+	var resp [48]byte
+	n, err := conn.Read(resp[:])
+	t4 := time.Now()
+	if err != nil {
+		return 0, err
+	}
+	if n < len(resp) || resp[0]&0x7 != 4 || resp[1] == 0 {
+		return 0, ErrResponse // not a server response or kiss-of-death
+	}
+
+	t2 := timestamp(resp[32:40]) // server receive
+	t3 := timestamp(resp[40:48]) // server transmit
+
+	return (t2.Sub(t1) + t3.Sub(t4)) / 2, nil
+}
+
+// timestamp converts a 64-bit NTP timestamp to a time.Time
+func timestamp(b []byte) time.Time {
+	sec := int64(binary.BigEndian.Uint32(b[:4])) - ntpEpoch
+	frac := int64(binary.BigEndian.Uint32(b[4:])) * 1e9 >> 32
+	return time.Unix(sec, frac)
+}
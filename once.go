@@ -0,0 +1,149 @@
+package passkey
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+)
+
+/*
+
+	ONCE
+	a per-client rate and anti-replay policy accepting at most one
+	request per client key in each interval
+
+	pk.OncePerInterval(func(r *http.Request) string { return r.Header.Get("X-Client-Id") })
+
+	token level replay protection rejects a repeated token while this
+	rejects a second request from the same client key in the interval
+	of the matched token even with a fresh token; the default store is
+	in memory and saved with Persist, and a shared OnceStore enforces
+	the policy across instances
+
+*/
+
+// ErrOnce is returned when a client key was already used in the interval
+var ErrOnce = errors.New("passkey: already used this interval")
+
+// OnceStore records the client key uses of an interval index
+type OnceStore interface {
+	Seen(key string, index uint64) bool // reports a prior use and records this one
+}
+
+// OncePerInterval rejects with ErrOnce a second request in the interval of
+// the matched token for the client key returned by keyFn; an empty key is
+// not limited; the in-memory store is used unless OnceStore sets another
+//
+//	pass nil to disable; default
+func (pk *Server) OncePerInterval(keyFn func(r *http.Request) string) *Server {
+
+	pk.onceKey = keyFn
+	if keyFn != nil && pk.onceStore == nil {
+		c := &onceCache{seen: make(map[string]uint64)}
+		pk.onceStore = c
+		pk.register(onceName, c)
+	}
+
+	return pk
+}
+
+// OnceStore sets the store recording client key uses for OncePerInterval,
+// such as a store shared by every instance; a store that implements
+// io.Closer is closed by Close and one that implements Persister is saved
+// and restored with the server state
+func (pk *Server) OnceStore(store OnceStore) *Server {
+
+	pk.onceStore = store
+	if c, ok := store.(io.Closer); ok {
+		pk.stores = append(pk.stores, c)
+	}
+	if p, ok := store.(Persister); ok {
+		pk.register(onceName, p)
+	} else {
+		delete(pk.persist, onceName)
+	}
+
+	return pk
+}
+
+// once applies the once per interval policy to the request authorized by
+// a token matched in the window
+func (pk *Server) once(r *http.Request, window Window) error {
+
+	if pk.onceKey == nil || pk.onceStore == nil {
+		return nil
+	}
+
+	key := pk.onceKey(r)
+	if len(key) == 0 {
+		return nil
+	}
+	if pk.onceStore.Seen(key, pk.CurrentIndex()+uint64(int64(window))) {
+		return ErrOnce
+	}
+
+	return nil
+}
+
+// onceName is the store name of the in-memory once cache
+const onceName = "passkey.once"
+
+// onceCache is the in-memory OnceStore; keeps the last interval index per
+// client key and prunes keys of expired intervals when the index advances
+type onceCache struct {
+	mu   sync.Mutex
+	seen map[string]uint64
+	last uint64 // highest index seen
+}
+
+// Seen reports whether the key was used in the interval index and records it
+func (c *onceCache) Seen(key string, index uint64) bool {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if index > c.last {
+		c.last = index
+		for k, i := range c.seen {
+			if i+2*maxSkew < index {
+				delete(c.seen, k)
+			}
+		}
+	}
+
+	if i, ok := c.seen[key]; ok && i >= index {
+		return true
+	}
+	c.seen[key] = index
+
+	return false
+}
+
+// Snapshot returns the recorded client key uses
+func (c *onceCache) Snapshot() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return json.Marshal(c.seen)
+}
+
+// Restore replaces the recorded client key uses with a Snapshot
+func (c *onceCache) Restore(b []byte) error {
+
+	seen := make(map[string]uint64)
+	if err := json.Unmarshal(b, &seen); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seen = seen
+	for _, i := range seen {
+		if i > c.last {
+			c.last = i
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,199 @@
+package passkey
+
+import (
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"time"
+)
+
+/*
+
+	DERIVE
+	per-route servers that share the secret and interval generator of a
+	parent server but apply their own acceptance policy
+
+	pk := passkey.NewServer(ctx, secret)
+	admin := pk.Derive(passkey.WithWindows(passkey.Current))
+	api := pk.Derive(passkey.WithHeaderKey("x-api-passkey"))
+
+	the derived server reads the parent cnp token set, secret, and rotation
+	secrets so only the parent runs a generator; Token, String, and the
+	secret accessors of a derived server report the parent secret; the
+	lifecycle is shared: closing the parent stops token generation for every derived server and
+	Close on a derived server does not stop the parent; secret rotation
+	through either applies to both
+
+*/
+
+// Option configures a derived server; see Derive
+type Option func(*Server)
+
+// Derive returns a server sharing the secret and interval generator of pk
+// with a copy of its settings overridden by opts; derive after Start so the
+// derived server inherits the configured interval; WithInterval and
+// WithAlgorithm are ignored with a warning since the derived server matches
+// the parent token set
+func (pk *Server) Derive(opts ...Option) *Server {
+
+	d := &Server{root: pk.gen()}
+	d.interval = pk.interval
+	d.algorithm = pk.algorithm
+	d.clock = pk.clock
+	d.counter = pk.counter
+	d.offset = pk.offset
+	d.obfuscate = pk.obfuscate
+	d.noPadding = pk.noPadding
+	d.versioned = pk.versioned
+	d.past, d.future = pk.past, pk.future
+	d.stamp = pk.stamp
+	d.bind = pk.bind
+	d.digits = pk.digits
+	d.domain = pk.domain
+	d.codec = pk.codec
+	d.hKey, d.cKey = pk.hKey, pk.cKey
+	d.preflight = pk.preflight
+	d.maxLen = pk.maxLen
+	d.strict = pk.strict
+	d.caseless = pk.caseless
+	d.hKeys = append([]string(nil), pk.hKeys...)
+	d.onSkew = pk.onSkew
+	d.sources = append([]TokenSource(nil), pk.sources...)
+	d.windows = append([]Window(nil), pk.windows...)
+	d.eKey = pk.eKey
+	d.log = pk.log
+	d.onceKey, d.onceStore = pk.onceKey, pk.onceStore
+	d.delay = pk.delay
+	d.expired = pk.expired
+	d.corr = pk.corr
+	d.trusted, d.trustXFF = append([]netip.Prefix(nil), pk.trusted...), pk.trustXFF
+	d.onReject = pk.onReject
+	d.audit = pk.audit
+	d.leaks = pk.leaks
+	d.debugWindow = pk.debugWindow
+	d.dryRun = pk.dryRun
+	d.requireTLS, d.trustProto = pk.requireTLS, pk.trustProto
+
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	// the parent token set fixes the interval and algorithm
+	if d.interval != pk.interval || d.algorithm != pk.algorithm {
+		warn("derived server keeps the parent interval %s and algorithm %s", pk.interval, pk.algorithm)
+		d.interval, d.algorithm = pk.interval, pk.algorithm
+	}
+
+	return d
+}
+
+// gen returns the server that owns the generator, secret, and rotation state
+func (pk *Server) gen() *Server {
+	if pk.root != nil {
+		return pk.root
+	}
+	return pk
+}
+
+// WithInterval sets the interval for VerifyAny; see Interval; ignored by
+// Derive since a derived server keeps the parent interval
+func WithInterval(interval time.Duration) Option {
+	return func(pk *Server) { pk.Interval(&interval) }
+}
+
+// WithAlgorithm sets the HMAC hash algorithm for VerifyAny; see Algorithm;
+// ignored by Derive since a derived server keeps the parent algorithm
+func WithAlgorithm(algorithm Algorithm) Option {
+	return func(pk *Server) { pk.Algorithm(algorithm) }
+}
+
+// WithCodec sets the token wire format; see Codec
+func WithCodec(codec TokenCodec) Option {
+	return func(pk *Server) { pk.Codec(codec) }
+}
+
+// WithHeaderKey sets the header key; see SetHeaderKey
+func WithHeaderKey(key string) Option {
+	return func(pk *Server) { pk.SetHeaderKey(&key) }
+}
+
+// WithHeaderKeys sets the candidate header keys; see SetHeaderKeys
+func WithHeaderKeys(keys ...string) Option {
+	return func(pk *Server) { pk.SetHeaderKeys(keys...) }
+}
+
+// WithSources sets the ordered token sources; see Sources
+func WithSources(sources ...TokenSource) Option {
+	return func(pk *Server) { pk.Sources(sources...) }
+}
+
+// WithStrict sets strict reserved bit checking; see Strict
+func WithStrict(strict bool) Option {
+	return func(pk *Server) { pk.Strict(strict) }
+}
+
+// WithMaxTokenLen sets the maximum token length; see MaxTokenLen
+func WithMaxTokenLen(n int) Option {
+	return func(pk *Server) { pk.MaxTokenLen(n) }
+}
+
+// WithPreflight sets CORS preflight pass through; see AllowPreflight
+func WithPreflight(allow bool) Option {
+	return func(pk *Server) { pk.AllowPreflight(allow) }
+}
+
+// WithOnSkew sets the skew hook; see OnSkew
+func WithOnSkew(fn func(window Window)) Option {
+	return func(pk *Server) { pk.OnSkew(fn) }
+}
+
+// WithLogger sets the structured logger; see Logger
+func WithLogger(log *slog.Logger) Option {
+	return func(pk *Server) { pk.Logger(log) }
+}
+
+// WithWindows sets the accepted windows; see Windows
+func WithWindows(windows ...Window) Option {
+	return func(pk *Server) { pk.Windows(windows...) }
+}
+
+// WithPastSkew sets the accepted past windows; see PastSkew
+func WithPastSkew(n int) Option {
+	return func(pk *Server) { pk.PastSkew(n) }
+}
+
+// WithFutureSkew sets the accepted future windows; see FutureSkew
+func WithFutureSkew(n int) Option {
+	return func(pk *Server) { pk.FutureSkew(n) }
+}
+
+// WithOnReject sets the fallback handler for a failed check; see OnReject
+func WithOnReject(fallback http.Handler) Option {
+	return func(pk *Server) { pk.OnReject(fallback) }
+}
+
+// Windows restricts the windows the server accepts, such as Current alone
+// for a sensitive route; pass none to accept every generated window
+func (pk *Server) Windows(windows ...Window) *Server {
+	pk.windows = windows
+	return pk
+}
+
+// accepts returns whether the window is allowed by the acceptance policy
+func (pk *Server) accepts(w Window) bool {
+
+	if past, future := pk.skew(); w < Window(-past) || w > Window(future) {
+		return false
+	}
+	if len(pk.windows) == 0 {
+		return true
+	}
+
+	for _, a := range pk.windows {
+		if a == w {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,89 @@
+package passkey
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeriveKeepsInterval(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// a client a minute ahead; its 30 second token rounds to an odd half
+	// minute so no one minute window can share its time input
+	at := time.Unix(60*28000000+10, 0)
+	clock := func() time.Time { return at }
+	ahead := func() time.Time { return at.Add(time.Minute) }
+
+	pk := new(Server)
+	pk.Secret(rfcSecret20)
+	pk.Clock(clock)
+	pk.Start(ctx)
+
+	if d := pk.Derive(WithAlgorithm(SHA256)); d.algorithm != pk.algorithm {
+		t.Errorf("derived algorithm %s", d.algorithm)
+	}
+	d := pk.Derive(WithInterval(30*time.Second), WithFutureSkew(3))
+	if d.interval != pk.interval {
+		t.Errorf("derived interval %s", d.interval)
+	}
+
+	half := 30 * time.Second
+	for _, tc := range []struct {
+		interval *time.Duration
+		err      error
+	}{
+		{nil, nil},
+		{&half, ErrUnauthorized},
+	} {
+		client := new(Client)
+		client.Secret(rfcSecret20)
+		client.Interval(tc.interval)
+		client.Clock(ahead)
+		if err := client.Start(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := d.Verify(client.Token()); err != tc.err {
+			t.Errorf("interval %s: got %v want %v", client.interval, err, tc.err)
+		}
+	}
+}
+
+func TestDeriveSecretMethods(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pk := NewServer(ctx, rfcSecret20)
+	d := pk.Derive(WithHeaderKey("x-api"))
+
+	if _, err := pk.Verify(d.Token()); err != nil {
+		t.Errorf("derived Token: %v", err)
+	}
+	if !strings.Contains(d.String(), "secret="+pk.Fingerprint()) {
+		t.Errorf("derived String: %s", d)
+	}
+	tokens := d.TokensForRange(time.Now(), time.Now())
+	if len(tokens) != 1 {
+		t.Fatalf("derived TokensForRange: %v", tokens)
+	}
+	if _, err := pk.Verify(tokens[0].Token); err != nil {
+		t.Errorf("derived TokensForRange: %v", err)
+	}
+	if got, _ := d.SecretMnemonic(); got == "" {
+		t.Error("derived SecretMnemonic: empty")
+	}
+
+	short := new(Server)
+	short.Secret("GEZDGNBVGY3TQOJQGEZDGNBVGY")
+	short.Start(ctx)
+	bits, notes := short.SecurityLevel()
+	want := fmt.Sprint(bits, notes)
+	if got := fmt.Sprint(short.Derive().SecurityLevel()); got != want {
+		t.Errorf("derived SecurityLevel: %s want %s", got, want)
+	}
+}
@@ -6,204 +6,1989 @@ import (
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
 	"encoding/base32"
 	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/netip"
 	"os"
+	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
+
+	"github.com/zxdev/passkey/mnemonic"
 )
 
 /*
 
-	PASSKEY
-	generates a rolling set of authention codes based on a specified
-	interval retaining the current, next, and previous code; uses a
-	shared secret between the server and clients
+	PASSKEY
+	generates a rolling set of authention codes based on a specified
+	interval retaining the current, next, and previous code; uses a
+	shared secret between the server and clients
+
+	each PassKey owns its secret, token set, and interval generator and
+	the package holds no mutable state, so a Client calling upstreams and
+	a Server accepting downstreams in one process run independently with
+	their own secrets and intervals
+
+*/
+
+// Algorithm is the HMAC hash function used to sign the interval
+type Algorithm int
+
+const (
+	SHA1   Algorithm = iota // default; 20-byte secret
+	SHA256                  // 32-byte secret
+	SHA512                  // 64-byte secret
+)
+
+// hash returns the hash constructor for the algorithm
+func (a Algorithm) hash() func() hash.Hash {
+	switch a {
+	case SHA256:
+		return sha256.New
+	case SHA512:
+		return sha512.New
+	}
+	return sha1.New
+}
+
+// Size returns the secret size in bytes that uses the full key space of
+// the algorithm; the hash output size
+func (a Algorithm) Size() int {
+	switch a {
+	case SHA256:
+		return sha256.Size
+	case SHA512:
+		return sha512.Size
+	}
+	return sha1.Size
+}
+
+// String returns the algorithm name
+func (a Algorithm) String() string {
+	switch a {
+	case SHA256:
+		return "SHA256"
+	case SHA512:
+		return "SHA512"
+	}
+	return "SHA1"
+}
+
+const (
+	minSecret = sha1.Size        // minimum generated secret size in bytes
+	minKey    = 16               // minimum accepted secret size; 128-bit TOTP key
+	maxSecret = sha512.BlockSize // maximum secret size in bytes; largest hash block
+)
+
+var (
+	// ErrSecretSize is returned when a requested secret size is out of range
+	ErrSecretSize = errors.New("passkey: invalid secret size")
+	// ErrNoSecret is returned when no secret is configured
+	ErrNoSecret = errors.New("passkey: no secret")
+	// ErrInterval is returned when the client and server intervals differ
+	ErrInterval = errors.New("passkey: interval mismatch")
+	// ErrNotStarted is returned when the interval generator was never started
+	ErrNotStarted = errors.New("passkey: not started")
+	// ErrFingerprint is returned when the client and server secrets differ
+	ErrFingerprint = errors.New("passkey: secret fingerprint mismatch")
+)
+
+// GenerateSecret returns a new random base32 encoded secret with the requested
+// bits of entropy; a multiple of 8 from 160 up to the 1024-bit SHA512 block size
+//
+//	pass 0 for the default 160-bits; SHA1 compatible
+func GenerateSecret(bits int) (string, error) {
+
+	if bits == 0 {
+		bits = minSecret * 8
+	}
+	if bits%8 != 0 || bits < minSecret*8 || bits > maxSecret*8 {
+		return "", ErrSecretSize
+	}
+
+	b := make([]byte, bits/8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base32.StdEncoding.EncodeToString(b), nil
+}
+
+// SecretsEqual reports whether the base32 encoded secrets a and b are equal
+// using a constant time comparison so it does not leak timing; secrets are
+// decoded as by Secret so padded and unpadded forms of a key are equal and
+// any secret that Secret rejects is never equal
+func SecretsEqual(a, b string) bool {
+
+	ba, ok := parseSecret(a)
+	if !ok {
+		return false
+	}
+	bb, ok := parseSecret(b)
+	if !ok {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare(ba, bb) == 1
+}
+
+// PassKey generats a time based authentication token set based using a shared
+// secret and a defined interval rolling authentication code generation ttl
+type PassKey struct {
+	interval  time.Duration                 // defaults to one-minute
+	secret    atomic.Pointer[[]byte]        // binary form of base32 secret; [A..Z,2..7]
+	cnp       [3]atomic.Uint64              // valid token set; past,current,furture
+	hKey      string                        // http header passkey name; token
+	cKey      string                        // canonical header key for lookup
+	clock     func() time.Time              // time source; defaults to time.Now
+	algorithm Algorithm                     // hmac hash; defaults to SHA1
+	output    io.Writer                     // generated secret notice; defaults to os.Stderr
+	generated bool                          // secret was generated by Start or Current
+	noPadding bool                          // tokens use unpadded base32
+	jitter    time.Duration                 // maximum random delay added to each period
+	offset    time.Duration                 // clock offset applied by now
+	versioned bool                          // structured Sec-Passkey header; v1 {token}
+	running   atomic.Bool                   // interval generator is running
+	counter   func() uint64                 // logical counter replacing the time interval
+	stop      atomic.Pointer[func()]        // stops the interval generator
+	obfuscate int                           // obfuscation bytes + 1; zero for the default
+	past      int                           // past skew windows + 1; zero for the default
+	future    int                           // future skew windows + 1; zero for the default
+	stamp     bool                          // obfuscation bytes carry a timestamp
+	early     time.Duration                 // client early switch slice; see EarlySwitch
+	bind      bool                          // tokens bound to the request method and path
+	ready     atomic.Pointer[chan struct{}] // closed on the first generator tick
+	digits    int                           // TOTP code digits; zero for the native format
+	domain    []byte                        // tagged domain label mixed into the HMAC input
+	stored    atomic.Int64                  // unix nano time the secret was stored
+	ring      atomic.Pointer[[]uint64]      // past windows beyond previous; see PastSkew
+	codec     TokenCodec                    // token wire format; nil for base32
+}
+
+// maxObfuscation is the maximum number of obfuscation bytes
+const maxObfuscation = 8
+
+// Obfuscation sets the number of random obfuscation bytes appended to the
+// 8-byte token value, 0 through 8; zero sends exactly the 8-byte token with
+// no per-request entropy source call since the obfuscation bytes are never
+// authenticated; client and server must agree; default 2; out of range
+// values are clamped with a warning
+//
+//	pass nil for default
+func (pk *PassKey) Obfuscation(n *int) *PassKey {
+
+	switch {
+	case n == nil:
+		pk.obfuscate = 0
+	case *n < 0:
+		warn("negative obfuscation %d; using 0", *n)
+		pk.obfuscate = 1
+	case *n > maxObfuscation:
+		warn("obfuscation %d exceeds %d; using %d", *n, maxObfuscation, maxObfuscation)
+		pk.obfuscate = maxObfuscation + 1
+	default:
+		pk.obfuscate = *n + 1
+	}
+
+	return pk
+}
+
+// size returns the decoded token size; the 8-byte token value and the
+// obfuscation bytes
+func (pk *PassKey) size() int {
+	if pk.obfuscate == 0 {
+		return 10
+	}
+	return 8 + pk.obfuscate - 1
+}
+
+// rawEncoding is the unpadded base32 token encoding
+var rawEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TokenNoPadding sets tokens to use the unpadded base32 form so no = padding
+// is sent in headers that some proxies mangle; the server accepts both the
+// padded and unpadded forms when set; default false
+func (pk *PassKey) TokenNoPadding(noPadding bool) *PassKey {
+	pk.noPadding = noPadding
+	return pk
+}
+
+// encoding returns the token encoding
+func (pk *PassKey) encoding() *base32.Encoding {
+	if pk.noPadding {
+		return rawEncoding
+	}
+	return base32.StdEncoding
+}
+
+// Output sets the writer for the generated secret notice emitted by Start;
+// by default the notice is written to os.Stderr only when it is a terminal
+func (pk *PassKey) Output(w io.Writer) *PassKey {
+	pk.output = w
+	return pk
+}
+
+// GeneratedSecret returns the base32 encoded secret when it was generated by
+// Start because none was configured; otherwise an empty string
+func (pk *PassKey) GeneratedSecret() string {
+	if !pk.generated {
+		return ""
+	}
+	return base32.StdEncoding.EncodeToString(pk.loadSecret())
+}
+
+// SecretGenerated reports whether the secret in use was created by Start
+// or Current because none was configured rather than supplied; a server
+// running on a generated secret rejects every real client so check it in
+// a startup health check
+//
+//	if pk.SecretGenerated() { log.Fatal("passkey: no secret configured") }
+func (pk *PassKey) SecretGenerated() bool {
+	return pk.generated
+}
+
+// notice writes the generated secret to the output writer or to os.Stderr
+// when it is a terminal so a live secret never leaks into a data stream
+func (pk *PassKey) notice() {
+
+	w := pk.output
+	if w == nil {
+		if !isTerminal(os.Stderr) {
+			return
+		}
+		w = os.Stderr
+	}
+
+	fmt.Fprintln(w, base32.StdEncoding.EncodeToString(pk.loadSecret()))
+}
+
+// isTerminal reports whether f is a character device
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// Algorithm sets the PassKey HMAC hash algorithm; default SHA1
+func (pk *PassKey) Algorithm(algorithm Algorithm) *PassKey {
+	pk.algorithm = algorithm
+	return pk
+}
+
+// Clock sets the PassKey time source used for token generation, verification,
+// and the generator boundary; default time.Now
+//
+//	pass nil for default
+func (pk *PassKey) Clock(clock func() time.Time) *PassKey {
+	pk.clock = clock
+	return pk
+}
+
+// now returns the current time from the configured time source with the
+// clock offset applied; the single time source of every time dependent
+// computation so an injected Clock drives them all consistently
+func (pk *PassKey) now() time.Time {
+	if pk.clock == nil {
+		return time.Now().Add(pk.offset)
+	}
+	return pk.clock().Add(pk.offset)
+}
+
+// Counter sets a logical counter, such as a committed transaction id or a
+// block height, that replaces the time derived interval so tokens rotate on
+// logical events; the counter value is the current window and the interval
+// becomes the period the generator polls the counter so keep it short
+//
+// client and server must observe consistent counter values; a client ahead
+// or behind by more than one is rejected like clock skew
+//
+//	pass nil for the default time interval
+func (pk *PassKey) Counter(counter func() uint64) *PassKey {
+	pk.counter = counter
+	return pk
+}
+
+// Interval sets the PassKey generation interval; default time.Minute; a
+// negative interval is replaced by the default with a warning
+//
+// a zero interval from any path, a nil or zero Interval, WithInterval(0),
+// or no Interval at all, is time.Minute; Start and CMD.Current apply the
+// default before the generator timer is created and tokens derived before
+// Start use it, so a zero interval never reaches the clock math or a timer
+//
+//	pass nil for default
+func (pk *PassKey) Interval(interval *time.Duration) *PassKey {
+
+	if interval != nil && *interval < 0 {
+		warn("negative interval %s; using default", *interval)
+		interval = nil
+	}
+	if interval == nil || *interval == 0 {
+		v := time.Minute
+		interval = &v
+	}
+	pk.interval = *interval
+	if pk.jitter > pk.interval {
+		warn("jitter %s exceeds interval %s; using the interval", pk.jitter, pk.interval)
+		pk.jitter = pk.interval
+	}
+
+	return pk
+}
+
+// SetHeaderKey sets the http.Request header passkey name for use by the
+// server to authenticate the clients access credential; default token
+//
+// the name is stored lowercase so it is valid across protocol versions;
+// net/http canonicalizes token to Token over HTTP/1 while HTTP/2 and HTTP/3
+// require the lowercase field name on the wire
+//
+//	pass nil for default
+func (pk *PassKey) SetHeaderKey(hkey *string) *PassKey {
+
+	if hkey == nil || len(*hkey) == 0 {
+		pk.hKey = "token"
+	} else {
+		pk.hKey = strings.ToLower(*hkey)
+	}
+	pk.cKey = http.CanonicalHeaderKey(pk.hKey)
+	return pk
+}
+
+// HeaderKey returns the http.Request header passkey name; default token
+func (pk *PassKey) HeaderKey() string {
+	if len(pk.hKey) == 0 {
+		return "token"
+	}
+	return pk.hKey
+}
+
+// version of the structured header token scheme
+const version = "v1"
+
+// StructuredHeader sets the structured header mode where the token is sent
+// as a versioned Sec-Passkey: v1 {token} header value so the scheme can evolve
+// without ambiguity; the header key is set to sec-passkey so call SetHeaderKey
+// after to override; the server rejects unknown versions; default false
+func (pk *PassKey) StructuredHeader(structured bool) *PassKey {
+	pk.versioned = structured
+	if structured {
+		pk.hKey = "sec-passkey"
+		pk.cKey = http.CanonicalHeaderKey(pk.hKey)
+	}
+	return pk
+}
+
+// BindRequest sets tokens bound to the request method and URL path, which
+// are added to the HMAC input, so a captured token can not be replayed
+// against another endpoint; Client.SetHeader binds to the outgoing request
+// and the server recomputes the tokens from the incoming request, one HMAC
+// per accepted window and secret since the token set can not be cached;
+// client and server must agree and see the same method and escaped path,
+// so a proxy that rewrites or normalizes the path breaks binding; default
+// false
+func (pk *PassKey) BindRequest(bind bool) *PassKey {
+	pk.bind = bind
+	return pk
+}
+
+// Domain mixes a per-service label into the HMAC input so a secret reused
+// across services yields distinct tokens per service that can not be
+// replayed against another; client and server must share the label; not
+// a substitute for separate secrets; no effect in TOTP mode
+//
+//	pass "" for no label; default
+func (pk *PassKey) Domain(label string) *PassKey {
+
+	pk.domain = nil
+	if len(label) > 0 {
+		// a zero tag and the length prefix keep the label apart from the
+		// request scope, which always starts with the method
+		pk.domain = binary.AppendUvarint([]byte{0}, uint64(len(label)))
+		pk.domain = append(pk.domain, label...)
+	}
+
+	return pk
+}
+
+// requestScope returns the binding scope of the request; the method and
+// the escaped URL path where an empty path is /
+func requestScope(r *http.Request) string {
+	path := r.URL.EscapedPath()
+	if len(path) == 0 {
+		path = "/"
+	}
+	return r.Method + " " + path
+}
+
+// scope returns the binding scope of the request in BindRequest mode or an
+// empty scope
+func (pk *Server) scope(r *http.Request) string {
+	if !pk.bind {
+		return ""
+	}
+	return requestScope(r)
+}
+
+// headerValue returns the header value for the current token
+func (pk *PassKey) headerValue() string {
+	if pk.versioned {
+		return version + " " + pk.Token()
+	}
+	return pk.Token()
+}
+
+// Secret sets the PassKey secret; accepts
+//
+//	[16]byte secret; 128-bit TOTP key of authenticator apps
+//	[20]byte secret
+//	[32]byte secret; SHA256
+//	[64]byte secret; SHA512
+//	32-character base32 encoded string secret; [A..Z,2..7]
+//	26-character unpadded base32 encoded 128-bit TOTP key
+//	longer base32 encoded string secret up to 1024-bits; see GenerateSecret
+//	[]byte secret material of any length; see DeriveSecret
+//
+// leading and trailing whitespace, such as the trailing newline of a secret
+// read from a file, is trimmed from a string secret; an empty string is
+// ignored and a string shorter than 26 characters, below the 128-bit minimum
+// key, is ignored with a warning so a secret is generated
+func (pk *PassKey) Secret(secret interface{}) *PassKey {
+
+	if s, ok := secret.(string); ok {
+		if s = strings.TrimSpace(s); len(s) < 26 {
+			if len(s) > 0 {
+				warn("secret of %d characters is below the 128-bit minimum; ignored", len(s))
+			}
+			return pk
+		}
+	}
+
+	b, ok := parseSecret(secret)
+	if !ok {
+		return nil
+	}
+	pk.storeSecret(b)
+	pk.generated = false
+
+	return pk
+}
+
+// parseSecret returns the binary secret for the forms accepted by Secret
+func parseSecret(secret interface{}) ([]byte, bool) {
+
+	switch v := secret.(type) {
+	case string:
+		v = strings.TrimSpace(v)
+		b, err := base32.StdEncoding.DecodeString(v)
+		if err != nil {
+			b, err = rawEncoding.DecodeString(v) // unpadded TOTP key
+		}
+		if err != nil || len(b) < minKey || len(b) > maxSecret {
+			return nil, false
+		}
+		return b, true
+
+	case [16]byte:
+		return append([]byte(nil), v[:]...), true
+	case [20]byte:
+		return append([]byte(nil), v[:]...), true
+	case [32]byte:
+		return append([]byte(nil), v[:]...), true
+	case [64]byte:
+		return append([]byte(nil), v[:]...), true
+	case []byte:
+		if len(v) == 0 {
+			return nil, false
+		}
+		return DeriveSecret(v), true
+	}
+
+	return nil, false
+}
+
+// DeriveSecret returns the 20-byte secret derived from secret material of
+// any length, such as a 32-byte KMS value, as the HKDF-Extract step with
+// SHA1 and the salt "passkey"; HMAC-SHA1(key "passkey", material) so both
+// sides compute the same secret from the same bytes
+func DeriveSecret(material []byte) []byte {
+	extract := hmac.New(sha1.New, []byte("passkey"))
+	extract.Write(material)
+	return extract.Sum(nil)
+}
+
+// SecretMnemonic returns the secret as a word list for human friendly
+// provisioning; see the mnemonic package
+func (pk *PassKey) SecretMnemonic() (string, error) {
+
+	if !pk.hasSecret() {
+		return "", ErrNoSecret
+	}
+
+	return mnemonic.Encode(pk.loadSecret()), nil
+}
+
+// SecretFromMnemonic sets the PassKey secret from a word list created
+// by SecretMnemonic
+func (pk *PassKey) SecretFromMnemonic(words string) error {
+
+	b, err := mnemonic.Decode(words)
+	if err != nil {
+		return err
+	}
+	if len(b) < minKey || len(b) > maxSecret {
+		return ErrSecretSize
+	}
+	pk.storeSecret(b)
+	pk.generated = false
+
+	return nil
+}
+
+// SecretFromEnv sets the PassKey secret from the base32 encoded secret in
+// the named environment variable; returns ErrNoSecret when it is unset or
+// empty and ErrSecretSize when it is not a valid secret
+//
+//	pk.SecretFromEnv("SECRET")
+func (pk *PassKey) SecretFromEnv(name string) error {
+
+	s := strings.TrimSpace(os.Getenv(name))
+	if len(s) == 0 {
+		return ErrNoSecret
+	}
+
+	b, ok := parseSecret(s)
+	if !ok {
+		return ErrSecretSize
+	}
+	pk.storeSecret(b)
+	pk.generated = false
+
+	return nil
+}
+
+// warn writes a configuration warning to os.Stderr
+func warn(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "passkey: "+format+"\n", args...)
+}
+
+// String returns a summary of the configuration safe for logging; the
+// secret is only shown as a one-way fingerprint so two processes can be
+// compared without exposing it
+func (pk *PassKey) String() string {
+	return pk.describe(pk.loadSecret())
+}
+
+// describe returns the configuration summary for the secret
+func (pk *PassKey) describe(secret []byte) string {
+	past, future := pk.skew()
+	return fmt.Sprintf("passkey{interval=%s header=%s algorithm=%s skew=-%d/+%d secret=%s}",
+		pk.interval, pk.HeaderKey(), pk.algorithm, past, future, fingerprint(secret))
+}
+
+// Fingerprint returns a short one-way fingerprint of the secret, the first
+// 8 hex characters of its SHA256 sum, so a client and server can confirm
+// they loaded the same secret without exposing it; "none" when unset
+func (pk *PassKey) Fingerprint() string {
+	return fingerprint(pk.loadSecret())
+}
+
+// fingerprint returns a short one-way fingerprint of the secret; the first
+// 8 hex characters of the SHA256 sum
+func fingerprint(secret []byte) string {
+	if len(secret) == 0 {
+		return "none"
+	}
+	sum := sha256.Sum256(secret)
+	return hex.EncodeToString(sum[:4])
+}
+
+// mustRead fills b from the entropy source and panics when it fails so a
+// failed source never yields predictable bytes
+func mustRead(b []byte) {
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Errorf("passkey: entropy source: %w", err))
+	}
+}
+
+// hasSecret reports whether a non-zero secret is configured
+func (pk *PassKey) hasSecret() bool {
+	b := pk.loadSecret()
+	return len(b) > 0 && !bytes.Equal(b, make([]byte, len(b)))
+}
+
+// loadSecret returns the binary secret
+func (pk *PassKey) loadSecret() []byte {
+	if b := pk.secret.Load(); b != nil {
+		return *b
+	}
+	return nil
+}
+
+// storeSecret sets the binary secret; safe to call while the generator runs
+func (pk *PassKey) storeSecret(b []byte) {
+	pk.secret.Store(&b)
+	pk.stored.Store(pk.now().UnixNano())
+}
+
+// Start token generator using the secret and interval or apply
+// default values when neither are configured; when a secret is
+// generated the secret in use will be emited on os.Stderr when it is
+// a terminal or the Output writer; see GeneratedSecret
+//
+// Start panics when the entropy source fails to generate a secret
+// rather than run on a predictable secret; see StartErr
+func (pk *PassKey) Start(ctx context.Context) {
+	if err := pk.StartErr(ctx); err != nil {
+		panic(err)
+	}
+}
+
+// StartErr is Start returning the error when the entropy source fails
+// to generate a secret; the generator is not started on error
+func (pk *PassKey) StartErr(ctx context.Context) error {
+
+	// default interval
+	if pk.interval == 0 {
+		pk.Interval(nil)
+	}
+
+	// default header name
+	if len(pk.hKey) == 0 {
+		pk.SetHeaderKey(nil)
+	}
+
+	// validate secret; or failover and generate new secret and emit
+	if !pk.hasSecret() {
+		b := make([]byte, pk.algorithm.Size())
+		if _, err := rand.Read(b); err != nil {
+			return fmt.Errorf("passkey: secret generation: %w", err)
+		}
+		pk.storeSecret(b)
+		pk.generated = true
+		pk.notice()
+	}
+
+	// a secret shorter than the hash output does not use the full key space
+	if n := len(pk.loadSecret()); n < pk.algorithm.Size() {
+		warn("%d-byte secret with %s; %d-bytes recommended", n, pk.algorithm, pk.algorithm.Size())
+	}
+
+	// generate the complete token set before returning so a request issued
+	// immediately after Start never sees an empty previous slot
+	pk.regenerate()
+
+	// configure interval generator; the token set is regenerated from the
+	// clock on each period so a jittered period never skews the token values
+	pk.halt() // stop a generator from a prior Start
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	stop := func() { cancel(); <-done }
+	pk.stop.Store(&stop)
+
+	timer := time.NewTimer(pk.period())
+	ready := make(chan struct{})
+	pk.ready.Store(&ready)
+	first := ready // closed by the generator; ready stays readable
+	pk.running.Store(true)
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				pk.running.Store(false)
+				return
+			case <-timer.C:
+				pk.regenerate()
+				if first != nil {
+					close(first) // first tick; see WaitReady
+					first = nil
+				}
+				timer.Reset(pk.period())
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Close stops the interval generator and waits for it to exit; idempotent
+// and safe to call when Start was never called
+func (pk *PassKey) Close() error {
+	pk.halt()
+	return nil
+}
+
+// halt stops the interval generator when running
+func (pk *PassKey) halt() {
+	if stop := pk.stop.Swap(nil); stop != nil {
+		(*stop)()
+	}
+}
+
+// WaitReady blocks until the interval generator has ticked at least once
+// or the context is done; Start already computes the complete token set so
+// this is only for flows that want a regenerated set; the first tick is at
+// the next interval boundary so the wait is up to one interval plus any
+// Jitter; returns ErrNotStarted when Start was never called
+func (pk *PassKey) WaitReady(ctx context.Context) error {
+
+	ready := pk.ready.Load()
+	if ready == nil {
+		return ErrNotStarted
+	}
+
+	select {
+	case <-*ready:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Jitter sets a maximum random delay added to each generator period so that
+// a fleet started together does not rotate in lockstep; default 0
+//
+// jitter only shifts when the generator fires and never what it generates;
+// the token set is always derived from the clock rounded to the interval so
+// a delayed rotation serves the prior set for up to max past the boundary,
+// which the server tolerates through the previous and next windows; keep
+// max small relative to the interval
+//
+// max is bounded 0 through the interval, or the default interval applied by
+// Start when none is set, and is clamped again when the interval is later
+// lowered; out of range values are clamped with a warning
+func (pk *PassKey) Jitter(max time.Duration) *PassKey {
+
+	switch {
+	case max < 0:
+		warn("negative jitter %s; using 0", max)
+		max = 0
+	case pk.interval > 0 && max > pk.interval:
+		warn("jitter %s exceeds interval %s; using the interval", max, pk.interval)
+		max = pk.interval
+	}
+	pk.jitter = max
+
+	return pk
+}
+
+// maxSkew is the maximum number of skew windows in either direction
+const maxSkew = 10
+
+// PastSkew sets the number of past windows accepted, 0 through 10, so a
+// client returning from offline with a stale token is accepted while the
+// future skew stays tight; the generator keeps windows beyond the previous
+// interval in a past ring sized to the skew so they cost no HMAC per
+// verify; set before Start or the ring is resized on the next period;
+// default 1; out of range values are clamped with a warning
+func (pk *PassKey) PastSkew(n int) *PassKey {
+	pk.past = clampSkew("past", n) + 1
+	return pk
+}
+
+// FutureSkew sets the number of future windows accepted, 0 through 10, so
+// a client slightly ahead of the server at an interval boundary is still
+// accepted; windows beyond the next interval cost one HMAC each per verify;
+// default 1; out of range values are clamped with a warning
+func (pk *PassKey) FutureSkew(n int) *PassKey {
+	pk.future = clampSkew("future", n) + 1
+	return pk
+}
+
+// clampSkew bounds the skew n to 0 through maxSkew
+func clampSkew(name string, n int) int {
+
+	switch {
+	case n < 0:
+		warn("negative %s skew %d; using 0", name, n)
+		return 0
+	case n > maxSkew:
+		warn("%s skew %d exceeds %d; using %d", name, n, maxSkew, maxSkew)
+		return maxSkew
+	}
+
+	return n
+}
+
+// skew returns the number of accepted past and future windows
+func (pk *PassKey) skew() (past, future int) {
+
+	past, future = 1, 1
+	if pk.past > 0 {
+		past = pk.past - 1
+	}
+	if pk.future > 0 {
+		future = pk.future - 1
+	}
+
+	return past, future
+}
+
+// period returns the time until the next interval boundary of the clock,
+// or the counter poll interval in Counter mode, with a random jitter added
+// so the generator regenerates the token set as the boundary passes
+func (pk *PassKey) period() time.Duration {
+
+	d := pk.interval
+	if pk.counter == nil {
+		d = pk.remaining()
+	}
+	if d <= 0 {
+		d = time.Minute // unreachable after Start applies the default interval
+	}
+	if pk.jitter <= 0 {
+		return d
+	}
+
+	var b [8]byte
+	mustRead(b[:])
+	return d + time.Duration(binary.LittleEndian.Uint64(b[:])%uint64(pk.jitter))
+}
+
+// regenerate the complete token set from the clock
+func (pk *PassKey) regenerate() {
+	pk.generate(2) // previous
+	pk.generate(0) // current
+	pk.generate(1) // next
+	pk.generatePast()
+}
+
+// generatePast regenerates the past ring of windows beyond the previous
+// window sized to the past skew so a wide PastSkew for clients returning
+// from offline costs no HMAC per verify; one HMAC per window each period
+func (pk *PassKey) generatePast() {
+
+	past, _ := pk.skew()
+	if past <= 1 {
+		pk.ring.Store(nil)
+		return
+	}
+
+	secret, t := pk.loadSecret(), pk.now()
+	ring := make([]uint64, past-1)
+	for i := range ring {
+		ring[i] = pk.derive(secret, t, -2-i)
+	}
+	pk.ring.Store(&ring)
+}
+
+// ringAt returns the past ring value of the window beyond the previous
+// window and whether the ring holds it
+func (pk *PassKey) ringAt(w Window) (uint64, bool) {
+
+	ring := pk.ring.Load()
+	if ring == nil || w >= Previous {
+		return 0, false
+	}
+	i := int(Previous - w - 1)
+	if i >= len(*ring) {
+		return 0, false
+	}
+
+	return (*ring)[i], true
+}
+
+// generate the token requeste
+//
+//	0: current
+//	1: next
+//	2: previous
+func (pk *PassKey) generate(i int) {
+	pk.cnp[i].Store(pk.derive(pk.loadSecret(), pk.now(), [3]int{0, 1, -1}[i]))
+}
+
+// derive the token for the secret and interval offset relative to the reference time t
+//
+//	-1: previous
+//	 0: current
+//	 1: next
+func (pk *PassKey) derive(secret []byte, t time.Time, offset int) uint64 {
+	return pk.deriveScope(secret, t, offset, "")
+}
+
+// deriveScope is derive with the request scope appended to the HMAC input
+// so the token is bound to the request; see BindRequest
+func (pk *PassKey) deriveScope(secret []byte, t time.Time, offset int, scope string) uint64 {
+
+	if pk.digits > 0 {
+		return pk.code(secret, t, offset) // RFC 6238 compatible; see TOTP
+	}
+
+	// generate int64 unix time, or the logical counter, as a slice of bytes;
+	// unix time is UTC so local DST transitions never move the boundary and
+	// a stepped leap second only repeats one second of the current window
+	var bs [8]byte // int64 time bytes
+	if pk.counter != nil {
+		binary.LittleEndian.PutUint64(bs[:], pk.counter()+uint64(offset))
+	} else {
+		interval := pk.interval
+		if interval <= 0 {
+			interval = time.Minute // default before Start; see Interval
+		}
+		r := t.UTC().Add(time.Duration(offset-1) * interval).Round(interval)
+		v := r.Unix()
+		if interval < time.Second {
+			v = r.UnixNano() // sub-second windows would repeat unix seconds
+		}
+		binary.LittleEndian.PutUint64(bs[:], uint64(v))
+	}
+
+	// sign time slice bytes with the secret using hmac sha1 to
+	// generate a unique reproduceable bytes slice hash
+	sign := hmac.New(pk.algorithm.hash(), secret)
+	sign.Write(bs[:])
+	if len(pk.domain) > 0 {
+		sign.Write(pk.domain)
+	}
+	if len(scope) > 0 {
+		io.WriteString(sign, scope)
+	}
+	hash := sign.Sum(nil)
+
+	// use the last nibble (a half-byte) to choose the start index since this value
+	// is at most 0xF (decimal 15), and there are at least 20 bytes of hash; we need
+	// 8 bytes for Uint64 from hash starting from n index
+	nibble := ((hash[len(hash)-1] & 0xf) / 2) + 1
+	return binary.LittleEndian.Uint64(hash[nibble : nibble+8])
+
+}
+
+// Token returns the current token from the generator as a base32 encoded
+// value with random obfuscation bits; the value set by Client.SetHeader
+// and returned by CMD.Current
+func (pk *PassKey) Token() string {
+	return pk.encode(pk.current())
+}
+
+// TimedToken is a pre-generated token and the span of time it is current
+type TimedToken struct {
+	Token string    `json:"token"` // base32 encoded token
+	From  time.Time `json:"from"`  // start of the interval
+	Until time.Time `json:"until"` // end of the interval; exclusive
+}
+
+// maxRange is the maximum number of tokens returned by TokensForRange
+const maxRange = 1440
+
+// TokensForRange returns the token of each interval from the one current at
+// start through the one current at end for an offline signer provisioning
+// an air-gapped client; nil in Counter mode or when end is before start
+//
+// every returned token is a valid credential for its interval so the batch
+// must be protected like the secret itself; at most 1440 tokens, a day of
+// one-minute intervals, are returned and a longer range is truncated with a
+// warning; tokens are not request bound and Stamp tokens carry the time of
+// generation so they are rejected as stale when replayed later
+func (pk *PassKey) TokensForRange(start, end time.Time) []TimedToken {
+	return pk.tokensForRange(pk.loadSecret(), start, end)
+}
+
+// tokensForRange returns the tokens of the secret from start through end
+func (pk *PassKey) tokensForRange(secret []byte, start, end time.Time) []TimedToken {
+
+	if pk.counter != nil || pk.interval <= 0 || end.Before(start) {
+		return nil
+	}
+
+	var tokens []TimedToken
+	for t := start; !t.After(end); {
+		if len(tokens) == maxRange {
+			warn("token range exceeds %d tokens; truncated at %s", maxRange, t.UTC().Format(time.RFC3339))
+			break
+		}
+		from, until := pk.span(t)
+		tokens = append(tokens, TimedToken{
+			Token: pk.encode(pk.derive(secret, t, int(Current))),
+			From:  from,
+			Until: until,
+		})
+		t = until
+	}
+
+	return tokens
+}
+
+// span returns the start and end of the interval current at t
+func (pk *PassKey) span(t time.Time) (from, until time.Time) {
+
+	if pk.digits > 0 {
+		step := pk.step()
+		from = time.Unix(0, t.UnixNano()-t.UnixNano()%int64(step)).UTC()
+		return from, from.Add(step)
+	}
+
+	until = t.UTC().Add(-pk.interval).Round(pk.interval).Add(pk.interval * 3 / 2)
+	return until.Add(-pk.interval), until
+}
+
+// current returns the current token value from the generator, or in early
+// switch mode derives the current or, within the early slice at the end of
+// the interval, the next token value from the clock
+func (pk *PassKey) current() uint64 {
+
+	if pk.early <= 0 || pk.counter != nil || pk.interval <= 0 {
+		return pk.cnp[0].Load()
+	}
+
+	return pk.derive(pk.loadSecret(), pk.now(), int(pk.window()))
+}
+
+// window returns the window of the token to send; Next within the early
+// switch slice at the end of the interval, otherwise Current
+func (pk *PassKey) window() Window {
+	if pk.early > 0 && pk.counter == nil && pk.interval > 0 && pk.remaining() <= pk.early {
+		return Next
+	}
+	return Current
+}
+
+// remaining returns the time until the current interval token changes
+func (pk *PassKey) remaining() time.Duration {
+	if pk.digits > 0 {
+		step := pk.step()
+		return step - time.Duration(pk.now().UnixNano()%int64(step))
+	}
+	t := pk.now().UTC().Add(-pk.interval)
+	return t.Round(pk.interval).Add(pk.interval / 2).Sub(t)
+}
+
+// CurrentIndex returns the interval index of the current window; the
+// rounded interval time divided by the interval, or the counter value in
+// Counter mode; client and server compute the same index so it can be
+// logged to correlate requests without revealing the token
+func (pk *PassKey) CurrentIndex() uint64 {
+
+	if pk.counter != nil {
+		return pk.counter()
+	}
+	return pk.TimeToIndex(pk.now())
+}
+
+// TimeToIndex returns the interval index of the window current at t using
+// the rounding of the token derivation; zero in Counter mode or before
+// Start sets the interval; see IndexToTime
+func (pk *PassKey) TimeToIndex(t time.Time) uint64 {
+
+	if pk.counter != nil || pk.interval <= 0 {
+		return 0
+	}
+	if pk.digits > 0 {
+		return uint64(t.UnixNano() / int64(pk.step()))
+	}
+
+	r := t.UTC().Add(-pk.interval).Round(pk.interval)
+	return uint64(r.UnixNano() / int64(pk.interval))
+}
+
+// IndexToTime returns the start of the window with the interval index, the
+// earliest time TimeToIndex maps to the index, so the two are exact inverses
+// for every index of a time in the int64 nanosecond range; the zero time in
+// Counter mode or before Start sets the interval
+func (pk *PassKey) IndexToTime(index uint64) time.Time {
+
+	if pk.counter != nil || pk.interval <= 0 {
+		return time.Time{}
+	}
+	if pk.digits > 0 {
+		return time.Unix(0, int64(index)*int64(pk.step())).UTC()
+	}
+
+	// the rounded time of the index window is the first multiple of the
+	// interval from the zero time, which time.Round uses, at or after the
+	// index multiple of the interval from the unix epoch
+	r := time.Unix(0, int64(index)*int64(pk.interval)).UTC().Add(pk.interval - 1).Truncate(pk.interval)
+	return r.Add(pk.interval / 2)
+}
+
+// encode the token value with random obfuscation bits
+func (pk *PassKey) encode(v uint64) string {
+	return pk.encodeID(v, nil)
+}
+
+// encodeID is encode with the obfuscation bytes taken from the correlation
+// bytes of a request ID in place of random bits when id is not nil; see
+// Client.Correlate
+func (pk *PassKey) encodeID(v uint64, id []byte) string {
+
+	if pk.digits > 0 {
+		return pk.encodeCode(v)
+	}
+
+	var buf [8 + maxObfuscation]byte
+	b := buf[:pk.size()]
+	if len(b) > 8 {
+		if id != nil {
+			copy(b[8:], id)
+		} else {
+			mustRead(b[8:]) // add random obfuscation bits
+		}
+		b[8] &^= reserved
+	}
+	if pk.stamp && len(b) >= 10 {
+		s := stamp(pk.now())
+		b[8], b[9] = byte(s>>8), byte(s) // reserved bit clear; 15-bit stamp
+	}
+	binary.LittleEndian.PutUint64(b, v)
+	if pk.codec != nil {
+		return pk.codec.Encode(b)
+	}
+	return pk.encoding().EncodeToString(b)
+}
+
+// stampMask bounds the obfuscation stamp to 15 bits so the reserved bit
+// stays clear
+const stampMask = 0x7fff
+
+// Stamp sets tokens to carry a 15-bit seconds timestamp in the first two
+// obfuscation bytes in place of random bits and the server to reject with
+// ErrStale a token whose stamp falls outside the accepted windows; catches
+// a long delayed replay without a cache but is a heuristic and not replay
+// protection since a captured token is still accepted within the window;
+// requires 2 or more obfuscation bytes; client and server must agree;
+// default false
+func (pk *PassKey) Stamp(stamp bool) *PassKey {
+	pk.stamp = stamp
+	return pk
+}
+
+// stamp returns the 15-bit seconds timestamp for t
+func stamp(t time.Time) uint16 {
+	return uint16(t.Unix()) & stampMask
+}
+
+/*
+
+	SERVER
+	wrapper for PassKey with addition server methods
+
+*/
+// NewServer configurator takes a shared secret; applies defaults and will generate and
+// emit a new secret on os.Stderr when required, and starts the interval generator
+func NewServer(ctx context.Context, secret string) *Server {
+	var server = new(Server)
+	server.Secret(secret)
+	server.Start(ctx)
+	return server
+}
+
+// Server methods
+type Server struct {
+	PassKey
+	preflight   bool                       // pass CORS preflight requests to next
+	keys        atomic.Pointer[[]*key]     // additional secrets accepted during rotation
+	maxLen      int                        // maximum token length; zero for the encoded length
+	strict      bool                       // reject tokens with reserved bits set
+	hKeys       []string                   // candidate header keys checked in order
+	onSkew      func(Window)               // called on a non-current window match
+	rotation    atomic.Pointer[string]     // sealed rotation notice for clients
+	sources     []TokenSource              // ordered token extractors
+	stores      []io.Closer                // pluggable stores closed by Close
+	store       Store                      // persistence store; see Persist
+	persist     map[string]Persister       // named state saved to the store
+	root        *Server                    // generator owner for a derived server
+	windows     []Window                   // accepted windows; nil for all
+	eKey        string                     // expires response header key; opt-in
+	log         *slog.Logger               // structured logger; nil logs nothing
+	caseless    bool                       // accept lowercase base32 tokens
+	onceKey     func(*http.Request) string // client key for OncePerInterval
+	onceStore   OnceStore                  // client key uses per interval
+	delay       time.Duration              // delay before a rejection response
+	expired     int                        // status code for expired tokens; zero disables
+	corr        bool                       // log the token correlation value
+	disabled    atomic.Bool                // primary secret rejected; see DisableSecret
+	trusted     []netip.Prefix             // source ranges passed without a token
+	trustXFF    bool                       // trust X-Forwarded-For for TrustedCIDRs
+	onReject    http.Handler               // fallback handler for a failed check
+	audit       func(AuditEvent)           // audit sink for every decision
+	leaks       bool                       // reject a token leaked into the URL or Referer
+	debugWindow bool                       // add the server window to 401 responses
+	dryRun      bool                       // log and audit failures but never reject
+	requireTLS  bool                       // reject requests not received over TLS
+	trustProto  bool                       // trust X-Forwarded-Proto for RequireTLS
+}
+
+// Close stops the interval generator, saves the persisted state when a
+// persistence store is set, and closes any pluggable stores that implement
+// io.Closer; idempotent and safe to call when Start was never called;
+// returns the first error
+func (pk *Server) Close() error {
+
+	pk.halt()
+
+	var err error
+	if pk.store != nil {
+		err = pk.Save()
+		pk.store = nil
+	}
+	for _, store := range pk.stores {
+		if e := store.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	pk.stores = nil
+
+	return err
+}
+
+// OnSkew sets a hook called with the matched window whenever a valid token
+// matches a window other than Current; a rising previous or next rate is an
+// early warning of client clock drift and the hook is purely observational
+func (pk *Server) OnSkew(fn func(window Window)) *Server {
+	pk.onSkew = fn
+	return pk
+}
+
+// Logger sets the structured logger for rejections, authorized requests,
+// and secret rotations; rejections log at warn with the outcome, error, and
+// remote address, authorized requests at debug with the matched window;
+// default nil logs nothing
+func (pk *Server) Logger(log *slog.Logger) *Server {
+	pk.log = log
+	return pk
+}
+
+// LogCorrelation adds the hex obfuscation bytes of the request token to the
+// Logger lines as correlation so a request from a client with Correlate can
+// be matched by its request ID; see Correlation; the bytes are outside the
+// HMAC and carry no trust; default false
+func (pk *Server) LogCorrelation(on bool) *Server {
+	pk.corr = on
+	return pk
+}
+
+// correlation returns the correlation log attribute of the request token
+// in LogCorrelation mode; the empty attribute is omitted by the logger
+func (pk *Server) correlation(r *http.Request) slog.Attr {
+
+	if !pk.corr {
+		return slog.Attr{}
+	}
+	values := pk.extract(r)
+	if len(values) == 0 || len(values[0]) > maxTokens*(pk.maxTokenLen()+2)+len(version)+1 {
+		return slog.Attr{}
+	}
+	token, err := pk.token(values[0])
+	if err != nil {
+		return slog.Attr{}
+	}
+	token, _, _ = strings.Cut(token, ",")
+
+	var buf [40]byte
+	b, err := pk.decodeTo(buf[:], []byte(strings.TrimSpace(token)))
+	if err != nil || len(b) <= 8 {
+		return slog.Attr{}
+	}
+
+	return slog.String("correlation", hex.EncodeToString(b[8:]))
+}
+
+// SetHeaderKey sets the single http.Request header passkey name; see
+// PassKey.SetHeaderKey
+//
+//	pass nil for default
+func (pk *Server) SetHeaderKey(hkey *string) *Server {
+	pk.PassKey.SetHeaderKey(hkey)
+	pk.hKeys = []string{pk.cKey}
+	return pk
+}
+
+// SetHeaderKeys sets a list of candidate http.Request header passkey names
+// checked in order where the first non-empty header value is used; enables
+// a header name migration where old and new clients send different names
+func (pk *Server) SetHeaderKeys(keys ...string) *Server {
+
+	pk.hKeys = pk.hKeys[:0]
+	for _, key := range keys {
+		if len(key) > 0 {
+			pk.hKeys = append(pk.hKeys, http.CanonicalHeaderKey(key))
+		}
+	}
+	if len(pk.hKeys) > 0 {
+		pk.hKey = strings.ToLower(pk.hKeys[0])
+		pk.cKey = pk.hKeys[0]
+	}
+
+	return pk
+}
+
+// extract returns the first non-empty token from the sources or the
+// passkey header values; repeated header values are each returned so a
+// proxy appended value or a client candidate list is checked
+func (pk *Server) extract(r *http.Request) []string {
+
+	if len(pk.sources) > 0 {
+		for _, source := range pk.sources {
+			if value := source(r); len(value) > 0 {
+				return []string{value}
+			}
+		}
+		return nil
+	}
+
+	if len(pk.hKeys) == 0 {
+		if len(pk.cKey) == 0 {
+			return r.Header.Values(pk.hKey)
+		}
+		return r.Header[pk.cKey]
+	}
+
+	// an empty header does not hide a later candidate
+	for _, key := range pk.hKeys {
+		for _, value := range r.Header[key] {
+			if len(strings.TrimSpace(value)) > 0 {
+				return r.Header[key]
+			}
+		}
+	}
+
+	return nil
+}
+
+// Strict sets the server to reject tokens that do not conform to the token
+// layout where the reserved obfuscation bits must be zero; clients always
+// clear the reserved bits so they remain available for versions or flags;
+// default false which ignores the obfuscation bits entirely
+func (pk *Server) Strict(strict bool) *Server {
+	pk.strict = strict
+	return pk
+}
+
+// MaxTokenLen sets the maximum token length accepted before decoding to bound
+// the parsing work of oversized header values; default is the encoded token
+// length for the configured obfuscation, 16 for the default token; bounded
+// 16 through 4096 where out of range values are clamped with a warning; a
+// header value longer than the tokens of a comma-joined list and the scheme
+// prefix is rejected before any parsing
+//
+//	pass 0 for default
+func (pk *Server) MaxTokenLen(n int) *Server {
+
+	switch {
+	case n < 0:
+		warn("negative max token length %d; using default", n)
+		n = 0
+	case n > 0 && n < 16:
+		warn("max token length %d is below a token; using 16", n)
+		n = 16
+	case n > 4096:
+		warn("max token length %d; using 4096", n)
+		n = 4096
+	}
+	pk.maxLen = n
+
+	return pk
+}
+
+// maxTokenLen returns the maximum token length; the configured length or
+// the encoded length of the configured token size
+func (pk *Server) maxTokenLen() int {
+	if pk.maxLen > 0 {
+		return pk.maxLen
+	}
+	if pk.codec != nil {
+		var buf [8 + maxObfuscation]byte
+		return len(pk.codec.Encode(buf[:pk.size()]))
+	}
+	return base32.StdEncoding.EncodedLen(pk.size())
+}
+
+// RejectDelay sets a delay before the rejection response is written to
+// slow online guessing; legitimate clients rarely fail so they see no
+// added latency; the delay holds no lock and ends early when the request
+// context is cancelled; default 0
+func (pk *Server) RejectDelay(d time.Duration) *Server {
+	if d < 0 {
+		warn("negative reject delay %s; using 0", d)
+		d = 0
+	}
+	pk.delay = d
+	return pk
+}
+
+// sleep waits the reject delay or until the context is done
+func (pk *Server) sleep(ctx context.Context) {
+	t := time.NewTimer(pk.delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
+
+// RequireTLS sets the server to reject with ErrInsecure, a 400, any request
+// not received over TLS so a token is never accepted from plaintext HTTP;
+// see TrustForwardedProto behind a TLS terminating proxy; default false
+func (pk *Server) RequireTLS(require bool) *Server {
+	pk.requireTLS = require
+	return pk
+}
+
+// TrustForwardedProto sets RequireTLS to accept a request with an
+// X-Forwarded-Proto: https header from a TLS terminating load balancer; only
+// enable it when the proxy overwrites the header since a client can set it;
+// default false
+func (pk *Server) TrustForwardedProto(trust bool) *Server {
+	pk.trustProto = trust
+	return pk
+}
+
+// isTLS reports whether the request was received over TLS or, when trusted,
+// forwarded from TLS by a proxy
+func (pk *Server) isTLS(r *http.Request) bool {
+	return r.TLS != nil || pk.trustProto && strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+// TrustedCIDRs sets the IsValid middleware to pass requests from the CIDR
+// ranges, or single addresses, through to the next handler without a token,
+// such as a sidecar on 127.0.0.0/8; the address is the host of RemoteAddr or
+// with TrustForwardedFor the last X-Forwarded-For address; RequireTLS still
+// applies; invalid ranges are skipped with a warning
+//
+//	pass none for no bypass; default
+func (pk *Server) TrustedCIDRs(cidrs ...string) *Server {
+
+	pk.trusted = pk.trusted[:0]
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			addr, aerr := netip.ParseAddr(cidr)
+			if aerr != nil {
+				warn("invalid trusted CIDR %q; skipped", cidr)
+				continue
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		pk.trusted = append(pk.trusted, prefix.Masked())
+	}
+
+	return pk
+}
+
+// TrustForwardedFor sets TrustedCIDRs to match the last X-Forwarded-For
+// address, the one appended by the proxy in front of the server, in place
+// of RemoteAddr; only enable it behind a proxy that appends the header since
+// a client can set it; default false
+func (pk *Server) TrustForwardedFor(trust bool) *Server {
+	pk.trustXFF = trust
+	return pk
+}
+
+// isTrusted reports whether the request address is in a trusted range
+func (pk *Server) isTrusted(r *http.Request) bool {
+
+	host := r.RemoteAddr
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if pk.trustXFF {
+		if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+			list := xff[len(xff)-1]
+			host = strings.TrimSpace(list[strings.LastIndexByte(list, ',')+1:])
+		}
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap() // ::ffff:127.0.0.1 matches 127.0.0.0/8
+
+	for _, prefix := range pk.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RejectLeaked sets the server to reject with ErrLeaked and 403 a request
+// whose valid token also appears in the URL path, the query, or the Referer
+// header, where it has likely leaked into a log or a link and should be
+// treated as compromised; the scan runs only for a valid token and is
+// bounded by the request size; the query is not scanned when the token was
+// read from it by a source such as QuerySource; default false
+func (pk *Server) RejectLeaked(reject bool) *Server {
+	pk.leaks = reject
+	return pk
+}
+
+// leaked reports whether a token of the header value list appears in the
+// request URL or Referer header; padding is trimmed so an escaped = does
+// not hide it
+func (pk *Server) leaked(r *http.Request, list string) bool {
+
+	query := !pk.fromQuery(r)
+	for i := 0; i < maxTokens; i++ {
+		token, rest, more := strings.Cut(list, ",")
+		if token = strings.TrimRight(strings.TrimSpace(token), "="); len(token) >= 8 {
+			if strings.Contains(r.URL.EscapedPath(), token) ||
+				query && strings.Contains(r.URL.RawQuery, token) ||
+				strings.Contains(r.Referer(), token) {
+				return true
+			}
+		}
+		if !more {
+			break
+		}
+		list = rest
+	}
+
+	return false
+}
+
+// fromQuery reports whether the sources read the token from the URL query,
+// as QuerySource does, by extracting it again from the request without the
+// query
+func (pk *Server) fromQuery(r *http.Request) bool {
+
+	if len(pk.sources) == 0 || len(r.URL.RawQuery) == 0 {
+		return false
+	}
+
+	values := pk.extract(r)
+	u := *r.URL
+	u.RawQuery = ""
+	bare := *r
+	bare.URL = &u
+	without := pk.extract(&bare)
+
+	return len(values) > 0 && (len(without) == 0 || without[0] != values[0])
+}
+
+// CaseInsensitive sets the server to accept tokens with lowercase base32
+// characters, such as a token header lowercased by an intermediary, by
+// uppercasing the token before it is decoded; default false
+func (pk *Server) CaseInsensitive(caseless bool) *Server {
+	pk.caseless = caseless
+	return pk
+}
+
+// AllowPreflight sets the IsValid middleware to pass CORS preflight requests
+// through to the next handler without authentication; browsers never send
+// custom headers on a preflight so it would otherwise be rejected; default false
+//
+// when a CORS middleware wraps IsValid it answers the preflight before IsValid
+// is reached and this is not required; when IsValid wraps the CORS middleware
+// this must be enabled so the preflight reaches it
+func (pk *Server) AllowPreflight(allow bool) *Server {
+	pk.preflight = allow
+	return pk
+}
+
+// isPreflight reports whether r is a CORS preflight request
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions &&
+		len(r.Header.Get("Origin")) > 0 &&
+		len(r.Header.Get("Access-Control-Request-Method")) > 0
+}
+
+// IsValid returns a http.Handler middleware for authentication; the
+// default hKey {token} is set when necessary; the request body is never
+// read so it composes before body limits and body parsing middleware and
+// next receives the body untouched
+//
+//	recovery -> logging -> IsValid -> body limit -> body parsing -> handler
+func (pk *Server) IsValid(next http.Handler) http.Handler {
+
+	// IsValid middleware validates the current header key:{value} for access or
+	// aborts with a http.StatusUnauthorized response
+	//return func(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if pk.VerifyRequest(w, r) {
+			next.ServeHTTP(w, r)
+		}
+
+	})
+
+}
+
+// IsValidWithKey returns a http.Handler middleware for authentication like
+// IsValid but reading the token from the header key for this mount alone,
+// leaving the server header key intact; mount after configuring the server
+// since the mount copies its settings; see Derive
+//
+//	api.Handle("/internal/", pk.IsValidWithKey("X-Internal", internal))
+func (pk *Server) IsValidWithKey(key string, next http.Handler) http.Handler {
+	return pk.Derive(WithHeaderKey(key), WithSources()).IsValid(next)
+}
+
+// IsValidJSON returns a http.Handler middleware for authentication like
+// IsValid but rejects with an application/json body for JSON APIs
+//
+//	{"error":"unauthorized"}, {"error":"bad_request"}, {"error":"forbidden"}, or {"error":"expired"}
+func (pk *Server) IsValidJSON(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if pk.verifyRequest(w, r, rejectJSON) {
+			next.ServeHTTP(w, r)
+		}
 
-*/
+	})
+}
 
-// PassKey generats a time based authentication token set based using a shared
-// secret and a defined interval rolling authentication code generation ttl
-type PassKey struct {
-	interval time.Duration    // defaults to one-minute
-	secret   [20]byte         // binary form of base32 secret; [A..Z,2..7]
-	cnp      [3]atomic.Uint64 // valid token set; past,current,furture
-	hKey     string           // http header passkey name; token
+// OnReject sets a fallback handler invoked in place of the failure response
+// when the request fails the passkey check, such as a legacy API key check
+// during an incremental migration; the fallback writes the final response
+// and calls its own next handler when it authorizes the request; the
+// passkey error is available from RejectError; RejectDelay does not apply
+//
+//	pk.OnReject(legacyAuth(api))
+//	router.Handle("/api/", pk.IsValid(api))
+//
+//	pass nil to write the failure response; default
+func (pk *Server) OnReject(fallback http.Handler) *Server {
+	pk.onReject = fallback
+	return pk
 }
 
-// Interval sets the PassKey generation interval; default time.Minute
+// DryRun sets the middleware to pass every request to next while logging
+// and auditing the requests it would reject with the would-reject outcome,
+// so the rejection rate and misconfigured clients show before enforcing;
+// OnReject, RejectDelay, and the failure response do not apply; a rollout
+// aid that authorizes every request, never leave it on
 //
-//	pass nil for default
-func (pk *PassKey) Interval(interval *time.Duration) *PassKey {
+//	pass false to enforce; default
+func (pk *Server) DryRun(on bool) *Server {
+	pk.dryRun = on
+	return pk
+}
 
-	if interval == nil || *interval == 0 {
-		v := time.Minute
-		interval = &v
-	}
-	pk.interval = *interval
+// rejectKey is the request context key of the passkey error; see RejectError
+type rejectKey struct{}
 
-	return pk
+// RejectError returns the passkey error of a request passed to the OnReject
+// fallback handler or nil
+func RejectError(r *http.Request) error {
+	err, _ := r.Context().Value(rejectKey{}).(error)
+	return err
 }
 
-// SetHeaderKey sets the http.Request header passkey name for use by the
-// server to authenticate the clients access credential; default token
+// VerifyRequest validates the request passkey header and writes the error
+// response on failure; returns whether the caller should proceed so it can
+// adapt to frameworks that pass an explicit next function
 //
-//	pass nil for default
-func (pk *PassKey) SetHeaderKey(hkey *string) *PassKey {
+//	if pk.VerifyRequest(w, r) { next() }
+func (pk *Server) VerifyRequest(w http.ResponseWriter, r *http.Request) bool {
+	return pk.verifyRequest(w, r, reject)
+}
 
-	if hkey == nil || len(*hkey) == 0 {
-		pk.hKey = "token"
-	} else {
-		pk.hKey = *hkey
+// verifyRequest validates the request and writes the failure response
+// with the reject func
+func (pk *Server) verifyRequest(w http.ResponseWriter, r *http.Request, reject func(http.ResponseWriter, int, error)) bool {
+
+	window, err := pk.authenticate(r)
+	if err != nil && pk.dryRun {
+		if pk.log != nil {
+			pk.log.Warn("passkey: would reject", "outcome", "would-reject", "error", err, "remote", r.RemoteAddr, pk.correlation(r))
+		}
+		if pk.audit != nil {
+			pk.record(r, "would-reject", 0, err)
+		}
+		return true
 	}
-	return pk
+	if err != nil && pk.onReject != nil {
+		if pk.log != nil {
+			pk.log.Debug("passkey: fallback", "outcome", "fallback", "error", err, "remote", r.RemoteAddr, pk.correlation(r))
+		}
+		if pk.audit != nil {
+			pk.record(r, "fallback", 0, err)
+		}
+		pk.onReject.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), rejectKey{}, err)))
+		return false
+	}
+	if err != nil {
+		if pk.log != nil {
+			pk.log.Warn("passkey: rejected", "outcome", "rejected", "error", err, "remote", r.RemoteAddr, pk.correlation(r))
+		}
+		if pk.audit != nil {
+			pk.record(r, "rejected", 0, err)
+		}
+		if pk.delay > 0 {
+			pk.sleep(r.Context())
+		}
+		code := pk.status(err)
+		if pk.debugWindow && code == http.StatusUnauthorized {
+			w.Header().Set(DebugWindowHeader, pk.debugValue())
+		}
+		reject(w, code, err)
+		return false
+	}
+	if pk.log != nil {
+		pk.log.Debug("passkey: authorized", "outcome", "authorized", "window", window.String(), "remote", r.RemoteAddr, pk.correlation(r))
+	}
+	if pk.audit != nil {
+		pk.record(r, "authorized", window, nil)
+	}
+
+	// pending rotation notice for clients; see RotateAndNotify
+	if notice := pk.gen().rotation.Load(); notice != nil {
+		w.Header().Set(RotateHeader, *notice)
+	}
+
+	// seconds until the matched token is no longer accepted
+	if len(pk.eKey) > 0 && pk.counter == nil && !(pk.preflight && isPreflight(r)) {
+		w.Header().Set(pk.eKey, strconv.Itoa(int(pk.expires(window)/time.Second)))
+	}
+
+	return true
 }
 
-// Secret sets the PassKey secret; accepts
+// DebugWindowHeader is the response header carrying the server window on a
+// rejection in DebugWindow mode
+const DebugWindowHeader = "X-Passkey-Server-Window"
+
+// DebugWindow sets the middleware to add the DebugWindowHeader with the
+// server current interval index and its UTC start to 401 responses so a
+// developer sees how far the client is off; compare with the client
+// CurrentIndex; a development aid that discloses the server clock, never
+// enable it in production; default false
 //
-//	[20]byte secret
-//	32-character base32 encoded string secret; [A..Z,2..7]
-func (pk *PassKey) Secret(secret interface{}) *PassKey {
+//	X-Passkey-Server-Window: 29738677 2026-10-16T14:33:30Z
+func (pk *Server) DebugWindow(on bool) *Server {
+	pk.debugWindow = on
+	return pk
+}
 
-	switch v := secret.(type) {
-	case string:
-		if len(v) == 32 {
-			b, err := base32.StdEncoding.DecodeString(v)
-			if err != nil || len(v) != 32 {
-				return nil
-			}
-			copy(pk.secret[:], b)
-		}
+// debugValue returns the DebugWindowHeader value; the index alone in
+// Counter mode
+func (pk *Server) debugValue() string {
 
-	case [20]byte:
-		copy(pk.secret[:], v[:])
+	index := pk.CurrentIndex()
+	value := strconv.FormatUint(index, 10)
+	if start := pk.IndexToTime(index); !start.IsZero() {
+		value += " " + start.Format(time.RFC3339)
 	}
 
+	return value
+}
+
+// ExpiresHeader is the default response header carrying the seconds until
+// the matched token is no longer accepted; see SetExpiresHeader
+const ExpiresHeader = "X-Passkey-Expires"
+
+// SetExpiresHeader sets the response header key that carries the seconds
+// until the matched token is no longer accepted on authorized responses so
+// a client knows when to refresh before a boundary failure; opt-in and not
+// set in Counter mode
+//
+//	pk.SetExpiresHeader(passkey.ExpiresHeader)
+//	pass an empty key to disable; default
+func (pk *Server) SetExpiresHeader(key string) *Server {
+	pk.eKey = key
 	return pk
 }
 
-// Start token generator using the secret and interval or apply
-// default values when neither are configured; when a secret is
-// generated the secret in use will be emited on os.Stdout
-func (pk *PassKey) Start(ctx context.Context) {
+// expires returns the time until a token matched in the window is no longer
+// accepted; the rest of the current interval plus the accepted windows the
+// matched window has left before it passes the past skew
+func (pk *Server) expires(window Window) time.Duration {
 
-	// default interval
-	if pk.interval == 0 {
-		pk.Interval(nil)
+	if pk.interval <= 0 {
+		return 0
 	}
 
-	// default header name
-	if len(pk.hKey) == 0 {
-		pk.SetHeaderKey(nil)
+	past, _ := pk.skew()
+	return pk.remaining() + time.Duration(int(window)+past)*pk.interval
+}
+
+// reject writes the empty body failure response with the status code
+func reject(w http.ResponseWriter, code int, err error) {
+	w.WriteHeader(code)
+}
+
+// rejectJSON writes the failure response with a small JSON error body
+func rejectJSON(w http.ResponseWriter, code int, err error) {
+
+	msg := "unauthorized"
+	switch {
+	case code == http.StatusBadRequest:
+		msg = "bad_request"
+	case code == http.StatusForbidden:
+		msg = "forbidden"
+	case err == ErrExpired:
+		msg = "expired"
 	}
 
-	// validate secret; or failover and generate new secret and emit
-	if bytes.Equal(pk.secret[:20], make([]byte, 20)) {
-		rand.Read(pk.secret[:])
-		fmt.Fprintln(os.Stdout, base32.StdEncoding.EncodeToString(pk.secret[:]))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	fmt.Fprintf(w, "{\"error\":%q}\n", msg)
+}
+
+// maxValues is the maximum number of repeated header values checked per
+// request; each value may itself hold up to maxTokens comma-joined tokens
+const maxValues = 4
+
+// Authenticate validates the request passkey header and returns nil on
+// success or the typed verification error without writing a response so
+// the caller decides the response; ErrMalformed, ErrWrongLength,
+// ErrReserved, ErrVersion, ErrStale, ErrInsecure, ErrExpired, ErrOnce,
+// ErrLeaked, or ErrUnauthorized
+func (pk *Server) Authenticate(r *http.Request) error {
+	_, err := pk.authenticate(r)
+	return err
+}
+
+// authenticate validates the request and returns the matched window
+func (pk *Server) authenticate(r *http.Request) (Window, error) {
+
+	if pk.preflight && isPreflight(r) {
+		return Current, nil
+	}
+	if pk.requireTLS && !pk.isTLS(r) {
+		return 0, ErrInsecure
+	}
+	if len(pk.trusted) > 0 && pk.isTrusted(r) {
+		return Current, nil
 	}
 
-	// generate token set
-	pk.generate(0) // current
-	pk.generate(1) // next
-	//pk.generate(2) // previous
+	values := pk.extract(r)
+	if len(values) == 0 {
+		values = []string{""}
+	}
+	if len(values) > maxValues {
+		values = values[:maxValues]
+	}
 
-	// configure interval generator
-	ticker := time.NewTicker(pk.interval)
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				ticker.Stop()
-				return
-			case <-ticker.C:
-				pk.cnp[2].Store(pk.cnp[0].Load()) // current -> previous
-				pk.cnp[0].Store(pk.cnp[1].Load()) // next -> current
-				pk.generate(1)                    // next
+	var err error
+	secret := contextSecret(r.Context())
+	// a value longer than a full token list and prefix is never parsed
+	limit := maxTokens*(pk.maxTokenLen()+2) + len(version) + 1
 
+	for _, value := range values {
+		if len(value) > limit {
+			err = ErrMalformed
+			continue
+		}
+		var token string
+		if token, err = pk.token(value); err != nil {
+			continue
+		}
+		var window Window
+		if window, err = pk.verifyList(token, pk.scope(r), secret); err == nil {
+			if pk.leaks && pk.leaked(r, token) {
+				return 0, ErrLeaked
 			}
+			return window, pk.once(r, window)
 		}
-	}()
+	}
 
+	return 0, err
 }
 
-// generate the token requeste
+// secretKey is the request context key of a resolved secret; see WithSecret
+type secretKey struct{}
+
+// WithSecret returns a context carrying the secret the server middleware
+// checks the request token against in place of its configured and rotation
+// secrets, for a gateway that resolves the tenant secret in an earlier
+// middleware; the token set is derived per request at one HMAC per window
 //
-//	0: current
-//	1: next
-//	2: previous
-func (pk *PassKey) generate(i int) {
+//	next.ServeHTTP(w, r.WithContext(passkey.WithSecret(r.Context(), tenant)))
+func WithSecret(ctx context.Context, secret [20]byte) context.Context {
+	return context.WithValue(ctx, secretKey{}, &secret)
+}
 
-	// generate int64 unix time as a slice of bytes
-	var bs [8]byte // int64 time bytes
-	binary.LittleEndian.PutUint64(bs[:], uint64(
-		time.Now().UTC().Add(time.Duration(i-1)*pk.interval).Round(pk.interval).Unix(),
-	))
+// contextSecret returns the secret of WithSecret or nil; the context holds a
+// pointer so reading it does not allocate on every request
+func contextSecret(ctx context.Context) []byte {
+	if secret, ok := ctx.Value(secretKey{}).(*[20]byte); ok {
+		return secret[:]
+	}
+	return nil
+}
 
-	// sign time slice bytes with the secret using hmac sha1 to
-	// generate a unique reproduceable bytes slice hash
-	sign := hmac.New(sha1.New, pk.secret[:])
-	sign.Write(bs[:])
-	hash := sign.Sum(nil)
+// IntervalHandler is a diagnostic http.HandlerFunc that writes the server
+// interval as a time.Duration string for use with Client.CheckInterval; mount
+// it outside IsValid so a client with a mismatched interval can still reach
+// it rather than receive the 401 the mismatch causes
+func (pk *Server) IntervalHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(pk.interval.String()))
+}
 
-	// use the last nibble (a half-byte) to choose the start index since this value
-	// is at most 0xF (decimal 15), and there are 20 bytes of SHA1; we need 8 bytes
-	// for Uint64 from hash starting from n index
-	nibble := ((hash[19] & 0xf) / 2) + 1
-	pk.cnp[i].Store(binary.LittleEndian.Uint64(hash[nibble : nibble+8]))
+// Fingerprint returns the one-way fingerprint of the secret tokens are
+// generated with; see PassKey.Fingerprint
+func (pk *Server) Fingerprint() string {
+	return fingerprint(pk.gen().loadSecret())
+}
 
+// Token returns the current token from the generator of the parent of a
+// derived server encoded with the server settings; see PassKey.Token
+func (pk *Server) Token() string {
+	return pk.encode(pk.gen().current())
 }
 
-/*
+// RequestToken returns the header value a Client with the same secret and
+// settings sets on the request r with SetHeader; the token is bound to the
+// request in BindRequest mode and carries the version in StructuredHeader
+// mode; for tests and tools that build requests without a Client
+func (pk *Server) RequestToken(r *http.Request) string {
 
-	SERVER
-	wrapper for PassKey with addition server methods
+	g := pk.gen()
+	v := g.current()
+	if pk.bind {
+		v = pk.deriveScope(g.loadSecret(), pk.now(), 0, requestScope(r))
+	}
 
-*/
-// NewServer configurator takes a shared secret; applies defaults and will generate and
-// emit a new secret on os.Stdout when required, and starts the interval generator
-func NewServer(ctx context.Context, secret string) *Server {
-	var server = new(Server)
-	server.Secret(secret)
-	server.Start(ctx)
-	return server
-}
+	value := pk.encode(v)
+	if pk.versioned {
+		value = version + " " + value
+	}
 
-// Server methods
-type Server struct {
-	PassKey
+	return value
 }
 
-// IsValid returns a http.Handler middleware for authentication; the
-// default hKey {token} is set when necessary
-func (pk *Server) IsValid(next http.Handler) http.Handler {
+// String returns the configuration summary with the fingerprint of the
+// secret tokens are generated with; see PassKey.String
+func (pk *Server) String() string {
+	return pk.describe(pk.gen().loadSecret())
+}
 
-	// IsValid middleware validates the current header key:{value} for access or
-	// aborts with a http.StatusUnauthorized response
-	//return func(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+// TokensForRange returns the tokens of the secret tokens are generated with;
+// see PassKey.TokensForRange
+func (pk *Server) TokensForRange(start, end time.Time) []TimedToken {
+	return pk.tokensForRange(pk.gen().loadSecret(), start, end)
+}
 
-		b, err := base32.StdEncoding.DecodeString(r.Header.Get(pk.hKey))
-		if err != nil || len(b) != 10 {
-			w.WriteHeader(http.StatusBadRequest) // 400
-			return
-		}
+// GeneratedSecret returns the generated secret of the parent of a derived
+// server; see PassKey.GeneratedSecret
+func (pk *Server) GeneratedSecret() string {
+	return pk.gen().PassKey.GeneratedSecret()
+}
 
-		// ignore random ofuscation bits
-		switch binary.LittleEndian.Uint64(b[:8]) {
-		case pk.cnp[0].Load():
-		case pk.cnp[1].Load():
-		case pk.cnp[2].Load():
-		default:
-			w.WriteHeader(http.StatusUnauthorized)
-			return
-		}
-		next.ServeHTTP(w, r)
+// SecretGenerated reports whether the parent of a derived server runs on a
+// generated secret; see PassKey.SecretGenerated
+func (pk *Server) SecretGenerated() bool {
+	return pk.gen().generated
+}
 
-	})
+// SecretMnemonic returns the secret tokens are generated with as a word
+// list; see PassKey.SecretMnemonic
+func (pk *Server) SecretMnemonic() (string, error) {
+	return pk.gen().PassKey.SecretMnemonic()
+}
 
+// FingerprintHandler is a diagnostic http.HandlerFunc that writes the server
+// secret fingerprint for use with Client.CheckFingerprint; mount it outside
+// IsValid so a client with a mismatched secret can still reach it
+func (pk *Server) FingerprintHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(pk.Fingerprint()))
 }
 
 /*
@@ -213,8 +1998,9 @@ func (pk *Server) IsValid(next http.Handler) http.Handler {
 
 */
 
-// NewClient configurator takea a shared secret; applies defaults and will generate and
-// emit a new secret on os.Stdout when required, and starts the interval generator
+// NewClient configurator takea a shared secret; applies defaults and starts the interval
+// generator; a client never generates a secret so when the secret is not valid the
+// generator is not started, use Client.Start directly to check the error
 func NewClient(ctx context.Context, secret string) *Client {
 
 	client := new(Client)
@@ -226,18 +2012,315 @@ func NewClient(ctx context.Context, secret string) *Client {
 // Client methods
 type Client struct {
 	PassKey
+	fallback atomic.Pointer[[]byte] // fallback secret sent during rotation
+	corrKey  string                 // request ID header key; see Correlate
+}
+
+// Fallback sets a fallback secret whose token is sent alongside the current
+// token as a comma-joined list so requests succeed whether or not the server
+// has rotated; accepts the same forms as Secret; see DropFallback
+func (pk *Client) Fallback(secret interface{}) error {
+
+	b, ok := parseSecret(secret)
+	if !ok {
+		return ErrSecretSize
+	}
+	pk.fallback.Store(&b)
+
+	return nil
+}
+
+// DropFallback stops sending the fallback secret token once the client has
+// confirmed the server honors the current secret
+func (pk *Client) DropFallback() {
+	pk.fallback.Store(nil)
+}
+
+// headerValue returns the header value for the current token and the
+// fallback secret token of the same window when set
+func (pk *Client) headerValue() string {
+
+	value := pk.PassKey.headerValue()
+	if fallback := pk.fallback.Load(); fallback != nil {
+		value += "," + pk.encode(pk.derive(*fallback, pk.now(), int(pk.window())))
+	}
+
+	return value
+}
+
+// Start token generator using the secret and interval; unlike the server a
+// client never generates a failover secret since its tokens could never match
+// the server so ErrNoSecret is returned when no valid secret is configured
+func (pk *Client) Start(ctx context.Context) error {
+	return pk.StartErr(ctx)
+}
+
+// StartErr is Start; it shadows the PassKey failover so a client started
+// through either method never runs on a generated secret
+func (pk *Client) StartErr(ctx context.Context) error {
+
+	if !pk.hasSecret() {
+		return ErrNoSecret
+	}
+
+	return pk.PassKey.StartErr(ctx)
+}
+
+// Offset sets a fixed clock offset applied to token generation to compensate
+// for a client clock known to be off relative to the server; a client clock
+// that is behind the server by d uses a positive d; default 0
+func (pk *Client) Offset(d time.Duration) *Client {
+	pk.offset = d
+	return pk
+}
+
+// EarlySwitch sets the client to send the next token within the last d of
+// each interval so a request in flight at the boundary arrives as the new
+// current token of the server; trades a tiny early switch for fewer
+// boundary failures and must stay below the server future skew tolerance;
+// tokens are derived from the clock in this mode at one HMAC per token;
+// bounded 0 through half the interval where out of range values are
+// clamped with a warning; default 0 disables
+func (pk *Client) EarlySwitch(d time.Duration) *Client {
+
+	switch {
+	case d < 0:
+		warn("negative early switch %s; using 0", d)
+		d = 0
+	case pk.interval > 0 && d > pk.interval/2:
+		warn("early switch %s exceeds half the interval %s; using %s", d, pk.interval, pk.interval/2)
+		d = pk.interval / 2
+	}
+	pk.early = d
+
+	return pk
+}
+
+// TokenBinary returns the current token as the raw 8-byte value with no
+// obfuscation bytes or base32 encoding for bandwidth constrained links;
+// see Server.VerifyBinary
+func (pk *Client) TokenBinary() (b [8]byte) {
+	binary.LittleEndian.PutUint64(b[:], pk.current())
+	return b
+}
+
+// TokenUint64 returns the current token value as an integer for JSON APIs
+// that carry the token as a number; like TokenBinary it has no obfuscation
+// bytes so pair it with a separate nonce field when replay protection
+// matters; see Server.VerifyUint64
+func (pk *Client) TokenUint64() uint64 {
+	return pk.current()
+}
+
+// CheckInterval requests the server interval from a Server.IntervalHandler at
+// the url and returns ErrInterval when it does not match the client interval;
+// a diagnostic for the persistent 401 responses a mismatch causes
+func (pk *Client) CheckInterval(url string) error {
+
+	body, err := pk.diagnostic(url, "interval")
+	if err != nil {
+		return err
+	}
+	interval, err := time.ParseDuration(body)
+	if err != nil {
+		return err
+	}
+	if interval != pk.interval {
+		return fmt.Errorf("%w; client %s server %s", ErrInterval, pk.interval, interval)
+	}
+
+	return nil
+}
+
+// CheckFingerprint requests the server secret fingerprint from a
+// Server.FingerprintHandler at the url and returns ErrFingerprint when it
+// does not match the client secret; a diagnostic for mismatched secrets
+func (pk *Client) CheckFingerprint(url string) error {
+
+	body, err := pk.diagnostic(url, "fingerprint")
+	if err != nil {
+		return err
+	}
+	if fp := pk.Fingerprint(); body != fp {
+		return fmt.Errorf("%w; client %s server %s", ErrFingerprint, fp, body)
+	}
+
+	return nil
+}
+
+// diagnostic requests the short plain text body of a diagnostic handler
+func (pk *Client) diagnostic(url, name string) (string, error) {
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	pk.SetHeader(req)
+
+	client := &http.Client{Timeout: time.Second * 10}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("passkey: %s http %d", name, resp.StatusCode)
+	}
+
+	var buf bytes.Buffer
+	buf.ReadFrom(io.LimitReader(resp.Body, 64))
+
+	return buf.String(), nil
+}
+
+// TokenPrevious returns the base32 encoded token for the previous interval
+// for a client that detects it is slightly ahead of a server that has not
+// yet rotated
+func (pk *Client) TokenPrevious() string {
+	return pk.encode(pk.derive(pk.loadSecret(), pk.now(), int(Previous)))
+}
+
+// TokenNext returns the base32 encoded token for the next interval for a
+// transport that switches early when NextRotation is imminent
+func (pk *Client) TokenNext() string {
+	return pk.encode(pk.derive(pk.loadSecret(), pk.now(), int(Next)))
+}
+
+// NextRotation returns when the current token is replaced by the next on the
+// client clock so a transport can send TokenNext within a few hundred
+// milliseconds of the boundary; the zero time in Counter mode; see
+// EarlySwitch to do this for every token
+func (pk *Client) NextRotation() time.Time {
+	if pk.counter != nil || pk.interval <= 0 {
+		return time.Time{}
+	}
+	return pk.now().Add(pk.remaining() - pk.offset) // Offset shifts now; see Offset
 }
 
 // SetHeader sets the req.Header hKey:{current} value
 func (pk *Client) SetHeader(req *http.Request) {
 
-	var b [10]byte
-	rand.Read(b[8:]) // add random obfuscation bits
-	binary.LittleEndian.PutUint64(b[:], pk.cnp[0].Load())
-	req.Header.Set(pk.hKey, base32.StdEncoding.EncodeToString(b[:]))
-	// this code is required to mirror the code in
-	// in cmd.Current() to generate a valid passkey
+	if pk.bind || len(pk.corrKey) > 0 {
+		req.Header.Set(pk.hKey, pk.requestValue(req))
+		return
+	}
+	req.Header.Set(pk.hKey, pk.headerValue())
+
+}
+
+// Transport returns a http.RoundTripper that sets a fresh token on every
+// request it sends with SetHeader; the http.Client calls RoundTrip once per
+// hop so each redirected request carries a token for the time it is sent
+// rather than the stale or dropped header of the original request
+//
+//	pass nil for http.DefaultTransport
+//
+//	client := &http.Client{Transport: pk.Transport(nil)}
+func (pk *Client) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{pk: pk, base: base}
+}
+
+// transport is the Client http.RoundTripper; see Transport
+type transport struct {
+	pk   *Client
+	base http.RoundTripper
+}
+
+// RoundTrip sets the token on a clone of the request, since a RoundTripper
+// must not modify the request, and sends it with the base transport
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	t.pk.SetHeader(req)
+	return t.base.RoundTrip(req)
+}
+
+// SetHeaderPair sets the current and next tokens as a comma-joined header
+// value so a request straddling the interval boundary on a slow network
+// still matches the server; derived from the clock at one HMAC per token
+func (pk *Client) SetHeaderPair(req *http.Request) {
+
+	var scope string
+	if pk.bind {
+		scope = requestScope(req)
+	}
+
+	t, secret, id := pk.now(), pk.loadSecret(), pk.correlation(req)
+	value := pk.encodeID(pk.deriveScope(secret, t, int(Current), scope), id) + "," +
+		pk.encodeID(pk.deriveScope(secret, t, int(Next), scope), id)
+	if pk.versioned {
+		value = version + " " + value
+	}
+	if fallback := pk.fallback.Load(); fallback != nil {
+		value += "," + pk.encodeID(pk.deriveScope(*fallback, t, int(Current), scope), id)
+	}
+
+	req.Header.Set(pk.hKey, value)
+}
+
+// requestValue returns the header value with tokens bound to the request
+// method and path in BindRequest mode and obfuscation bytes correlated with
+// the request ID in Correlate mode; derived from the clock at one HMAC per
+// token
+func (pk *Client) requestValue(req *http.Request) string {
+
+	var scope string
+	if pk.bind {
+		scope = requestScope(req)
+	}
+
+	t, w, id := pk.now(), int(pk.window()), pk.correlation(req)
+	value := pk.encodeID(pk.deriveScope(pk.loadSecret(), t, w, scope), id)
+	if pk.versioned {
+		value = version + " " + value
+	}
+	if fallback := pk.fallback.Load(); fallback != nil {
+		value += "," + pk.encodeID(pk.deriveScope(*fallback, t, w, scope), id)
+	}
+
+	return value
+}
+
+// Correlate sets the client to derive the token obfuscation bytes from the
+// request ID in the header key, such as X-Request-ID, in place of random
+// bits so a server with LogCorrelation logs a value that can be matched to
+// the request; a request without the header gets random bits; the bytes
+// are outside the HMAC so they carry no trust and validation is unchanged;
+// with Stamp the stamp takes the first two bytes; see Correlation
+//
+//	pass "" to disable; default
+func (pk *Client) Correlate(key string) *Client {
+	pk.corrKey = key
+	return pk
+}
+
+// correlation returns the correlation bytes of the request ID in Correlate
+// mode or nil
+func (pk *Client) correlation(req *http.Request) []byte {
+
+	if len(pk.corrKey) == 0 {
+		return nil
+	}
+	id := req.Header.Get(pk.corrKey)
+	if len(id) == 0 {
+		return nil
+	}
+
+	sum := sha256.Sum256([]byte(id))
+	sum[0] &^= reserved
+
+	return sum[:maxObfuscation]
+}
 
+// Correlation returns the hex correlation value of the request ID; a server
+// with LogCorrelation logs a prefix of it, two hex characters per
+// obfuscation byte, for a token from a client with Correlate
+func Correlation(requestID string) string {
+	sum := sha256.Sum256([]byte(requestID))
+	sum[0] &^= reserved
+	return hex.EncodeToString(sum[:maxObfuscation])
 }
 
 /*
@@ -245,6 +2328,7 @@ func (pk *Client) SetHeader(req *http.Request) {
 	COMMAND LINE
 	wrapper for PassKey command line utilty without
 	the interval generator; only provide current now
+	unless started for long-lived interactive use
 
 */
 
@@ -255,12 +2339,18 @@ type CMD struct {
 
 // Show returns the base32 encoded shared secret
 func (pk *CMD) Show() string {
-	return base32.StdEncoding.EncodeToString(pk.secret[:])
+	return base32.StdEncoding.EncodeToString(pk.loadSecret())
 }
 
-// Current returns a current valid token based on the shared secret
+// Current returns a current valid token based on the shared secret; once
+// the interval generator is running via Start the secret is ignored and the
+// current token is read from the generator without regenerating it
 func (pk *CMD) Current(secret string) string {
 
+	if pk.running.Load() {
+		return pk.Token()
+	}
+
 	// default interval
 	if pk.interval == 0 {
 		pk.Interval(nil)
@@ -269,18 +2359,15 @@ func (pk *CMD) Current(secret string) string {
 	pk.Secret(secret)
 
 	// validate secret; or failover and generate
-	if bytes.Equal(pk.secret[:20], make([]byte, 20)) {
-		rand.Read(pk.secret[:])
+	if !pk.hasSecret() {
+		b := make([]byte, pk.algorithm.Size())
+		mustRead(b)
+		pk.storeSecret(b)
+		pk.generated = true
 	}
 
 	// generate current token
 	pk.generate(0) // current
 
-	var b [10]byte
-	rand.Read(b[8:]) // add random obfuscation bits
-	binary.LittleEndian.PutUint64(b[:], pk.cnp[0].Load())
-	return base32.StdEncoding.EncodeToString(b[:])
-	// this code is required to mirror the code in
-	// client.SetHeader() to generate a valid passkey
-
+	return pk.Token()
 }
@@ -0,0 +1,586 @@
+package passkey
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClientNoFailover(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for name, start := range map[string]func(*Client) error{
+		"Start":    func(pk *Client) error { return pk.Start(ctx) },
+		"StartErr": func(pk *Client) error { return pk.StartErr(ctx) },
+	} {
+		pk := new(Client)
+		if err := start(pk); err != ErrNoSecret {
+			t.Errorf("%s: got %v want ErrNoSecret", name, err)
+		}
+		if pk.SecretGenerated() {
+			t.Errorf("%s: generated a failover secret", name)
+		}
+	}
+}
+
+// message derives the token value of the scheme in vectors.go for the unix
+// time value of the rounded window
+func message(secret []byte, v int64) uint64 {
+
+	var bs [8]byte
+	binary.LittleEndian.PutUint64(bs[:], uint64(v))
+	sign := hmac.New(sha1.New, secret)
+	sign.Write(bs[:])
+	hash := sign.Sum(nil)
+	nibble := ((hash[len(hash)-1] & 0xf) / 2) + 1
+
+	return binary.LittleEndian.Uint64(hash[nibble : nibble+8])
+}
+
+func TestVectorsMatch(t *testing.T) {
+	for _, v := range TestVectors() {
+		if !CheckVector(v) {
+			t.Errorf("vector %s %s %s", v.Algorithm, v.Interval, v.Time)
+		}
+	}
+}
+
+func TestIntervalMessage(t *testing.T) {
+
+	secret, _ := parseSecret(rfcSecret20)
+	at := time.Unix(1234567890, 250e6)
+
+	// whole and fractional second intervals of a second or more keep the
+	// unix seconds message; shorter intervals use unix nanoseconds
+	for _, interval := range []time.Duration{time.Second, 1500 * time.Millisecond, 2500 * time.Millisecond, time.Minute, 500 * time.Millisecond, 100 * time.Millisecond} {
+		var pk PassKey
+		pk.Interval(&interval)
+		r := at.Add(-interval).Round(interval)
+		want := message(secret, r.Unix())
+		if interval < time.Second {
+			want = message(secret, r.UnixNano())
+		}
+		if got := pk.derive(secret, at, 0); got != want {
+			t.Errorf("interval %s: message changed", interval)
+		}
+	}
+
+	// consecutive sub-second windows never share a token
+	interval := 100 * time.Millisecond
+	var pk PassKey
+	pk.Interval(&interval)
+	seen := make(map[uint64]bool)
+	for i := 0; i < 50; i++ {
+		v := pk.derive(secret, at.Add(time.Duration(i)*interval), 0)
+		if seen[v] {
+			t.Fatalf("window %d repeats a token", i)
+		}
+		seen[v] = true
+	}
+}
+
+func TestDaylightSaving(t *testing.T) {
+
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	secret, _ := parseSecret(rfcSecret20)
+	var pk PassKey
+	pk.Interval(nil)
+
+	// across the spring forward and fall back transitions each minute is
+	// one window whose token matches the same instant in UTC
+	for _, transition := range []time.Time{
+		time.Date(2026, 3, 8, 7, 0, 0, 0, time.UTC),
+		time.Date(2026, 11, 1, 6, 0, 0, 0, time.UTC),
+	} {
+		seen := make(map[uint64]bool)
+		for i := -90; i < 90; i++ {
+			at := transition.Add(time.Duration(i) * time.Minute)
+			v := pk.derive(secret, at.In(loc), 0)
+			if v != pk.derive(secret, at, 0) {
+				t.Fatalf("%s: local time changed the token", at.In(loc))
+			}
+			if seen[v] {
+				t.Fatalf("%s: window repeated", at.In(loc))
+			}
+			seen[v] = true
+		}
+	}
+}
+
+func TestLeapSecond(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// a client stepping 23:59:59 twice for the 2016 leap second runs a
+	// second behind the server for the rest of the window
+	leap := time.Date(2016, 12, 31, 23, 59, 59, 0, time.UTC)
+	for _, d := range []time.Duration{0, 500 * time.Millisecond, time.Second, 20 * time.Second, 31 * time.Second} {
+		at := leap.Add(d)
+
+		server := new(Server)
+		server.Secret(rfcSecret20)
+		server.Clock(func() time.Time { return at.Add(time.Second) })
+		server.Start(ctx)
+
+		client := new(Client)
+		client.Secret(rfcSecret20)
+		client.Clock(func() time.Time { return at })
+		client.Start(ctx)
+
+		w, err := server.Verify(client.Token())
+		if err != nil {
+			t.Fatalf("%s: %v", at, err)
+		}
+		if want := server.TimeToIndex(at) - server.CurrentIndex(); uint64(int64(w)) != want {
+			t.Errorf("%s: window %s", at, w)
+		}
+	}
+}
+
+func TestNextRotationOffset(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// windows turn at half past each minute; the client runs 10s behind the
+	// server so its clock reaches the boundary 10s earlier
+	at := time.Unix(60*28000000, 0)
+	pk := new(Client)
+	pk.Secret(rfcSecret20)
+	pk.Clock(func() time.Time { return at })
+	pk.Offset(10 * time.Second)
+	pk.Start(ctx)
+
+	if got, want := pk.NextRotation(), at.Add(20*time.Second); !got.Equal(want) {
+		t.Errorf("next rotation %s want %s", got, want)
+	}
+}
+
+func TestHTTP2(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, key := range []string{"", "X-PassKey", "x-passkey-token"} {
+		pk := NewServer(ctx, rfcSecret20)
+		pk.SetHeaderKey(&key)
+		client := NewClient(ctx, rfcSecret20)
+		client.SetHeaderKey(&key)
+
+		var proto int
+		ts := httptest.NewUnstartedServer(pk.IsValid(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			proto = r.ProtoMajor
+		})))
+		ts.EnableHTTP2 = true
+		ts.StartTLS()
+
+		req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+		client.SetHeader(req)
+		resp, err := ts.Client().Do(req)
+		ts.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || resp.ProtoMajor != 2 || proto != 2 {
+			t.Errorf("header %q: status %d proto %d server proto %d", key, resp.StatusCode, resp.ProtoMajor, proto)
+		}
+	}
+}
+
+func TestClamp(t *testing.T) {
+
+	negative, over := -time.Second, 9
+	below, ten := -1, 10*time.Second
+	for _, tc := range []struct {
+		name      string
+		configure func(*Server)
+		check     func(*Server) bool
+	}{
+		{"negative interval", func(pk *Server) { pk.Interval(&negative) }, func(pk *Server) bool { return pk.interval == time.Minute }},
+		{"negative jitter", func(pk *Server) { pk.Interval(nil).Jitter(-time.Second) }, func(pk *Server) bool { return pk.jitter == 0 }},
+		{"jitter over interval", func(pk *Server) { pk.Interval(nil).Jitter(time.Hour) }, func(pk *Server) bool { return pk.jitter == time.Minute }},
+		{"jitter over later interval", func(pk *Server) { pk.Jitter(time.Hour).Interval(&ten) }, func(pk *Server) bool { return pk.jitter == ten }},
+		{"negative obfuscation", func(pk *Server) { pk.Obfuscation(&below) }, func(pk *Server) bool { return pk.size() == 8 }},
+		{"obfuscation over max", func(pk *Server) { pk.Obfuscation(&over) }, func(pk *Server) bool { return pk.size() == 8+maxObfuscation }},
+		{"negative max token length", func(pk *Server) { pk.MaxTokenLen(-1) }, func(pk *Server) bool { return pk.maxTokenLen() == 16 }},
+		{"max token length below a token", func(pk *Server) { pk.MaxTokenLen(4) }, func(pk *Server) bool { return pk.maxTokenLen() == 16 }},
+		{"max token length over 4096", func(pk *Server) { pk.MaxTokenLen(1 << 20) }, func(pk *Server) bool { return pk.maxTokenLen() == 4096 }},
+		{"negative skew", func(pk *Server) { pk.PastSkew(-1).FutureSkew(-1) }, func(pk *Server) bool { p, f := pk.skew(); return p == 0 && f == 0 }},
+		{"skew over max", func(pk *Server) { pk.PastSkew(99).FutureSkew(99) }, func(pk *Server) bool { p, f := pk.skew(); return p == maxSkew && f == maxSkew }},
+	} {
+		pk := new(Server)
+		tc.configure(pk)
+		if !tc.check(pk) {
+			t.Errorf("%s: not clamped", tc.name)
+		}
+	}
+}
+
+func TestJitterBeforeStart(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pk := new(Server)
+	pk.Secret(rfcSecret20)
+	pk.Jitter(time.Hour)
+	pk.Start(ctx)
+	if pk.jitter != time.Minute {
+		t.Errorf("jitter %s want %s", pk.jitter, time.Minute)
+	}
+	if d := pk.period(); d > 2*time.Minute {
+		t.Errorf("period %s exceeds interval and jitter", d)
+	}
+}
+
+func TestStringSecret(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	generated := new(Server)
+	generated.Output(io.Discard)
+	generated.Start(ctx)
+
+	for _, pk := range []*Server{NewServer(ctx, rfcSecret20), NewServer(ctx, rfcSecret64), generated} {
+		secret := pk.loadSecret()
+		forms := []string{
+			base32.StdEncoding.EncodeToString(secret),
+			base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret),
+			hex.EncodeToString(secret),
+			string(secret),
+		}
+		for _, s := range []string{pk.String(), fmt.Sprint(pk), fmt.Sprintf("%+v", pk), pk.PassKey.String()} {
+			for _, form := range forms {
+				if strings.Contains(s, form) || strings.Contains(strings.ToLower(s), strings.ToLower(form)) {
+					t.Fatalf("secret in %s", s)
+				}
+			}
+			if !strings.Contains(s, "secret="+pk.Fingerprint()) {
+				t.Errorf("no fingerprint in %s", s)
+			}
+		}
+	}
+}
+
+func TestIndependentInstances(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	type pair struct {
+		server *Server
+		client *Client
+	}
+	var pairs []pair
+	for _, tc := range []struct {
+		secret   string
+		interval time.Duration
+	}{
+		{rfcSecret20, time.Minute},
+		{rfcSecret32, 30 * time.Second},
+		{rfcSecret64, time.Second},
+	} {
+		interval := tc.interval
+		server := new(Server)
+		server.Secret(tc.secret)
+		server.Interval(&interval)
+		server.Start(ctx)
+		client := new(Client)
+		client.Secret(tc.secret)
+		client.Interval(&interval)
+		if err := client.Start(ctx); err != nil {
+			t.Fatal(err)
+		}
+		pairs = append(pairs, pair{server, client})
+	}
+
+	var wg sync.WaitGroup
+	for i, p := range pairs {
+		for j, q := range pairs {
+			wg.Add(1)
+			go func(i, j int, p, q pair) {
+				defer wg.Done()
+				for k := 0; k < 200; k++ {
+					_, err := p.server.Verify(q.client.Token())
+					if i == j && err != nil {
+						t.Errorf("server %d client %d: %v", i, j, err)
+						return
+					}
+					if i != j && err == nil {
+						t.Errorf("server %d accepted client %d", i, j)
+						return
+					}
+				}
+			}(i, j, p, q)
+		}
+	}
+	wg.Wait()
+}
+
+func TestSecretWhitespace(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pk := NewServer(ctx, rfcSecret20)
+	for _, secret := range []string{rfcSecret20 + "\n", rfcSecret20 + "\r\n", " " + rfcSecret20, "\t" + rfcSecret20 + " \n"} {
+		client := NewClient(ctx, secret)
+		if client.Fingerprint() != pk.Fingerprint() {
+			t.Errorf("%q: fingerprint %s want %s", secret, client.Fingerprint(), pk.Fingerprint())
+			continue
+		}
+		if _, err := pk.Verify(client.Token()); err != nil {
+			t.Errorf("%q: %v", secret, err)
+		}
+		if !SecretsEqual(secret, rfcSecret20) {
+			t.Errorf("%q: not equal", secret)
+		}
+	}
+
+	rotation := NewServer(ctx, rfcSecret32)
+	if err := rotation.AddSecret(rfcSecret20 + "\n"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rotation.Verify(NewClient(ctx, rfcSecret20).Token()); err != nil {
+		t.Errorf("rotation secret: %v", err)
+	}
+}
+
+func TestFirstRequestAfterStart(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := NewClient(ctx, rfcSecret20)
+	for i := 0; i < 20; i++ {
+		pk := NewServer(ctx, rfcSecret20)
+		for _, token := range []string{client.Token(), client.TokenPrevious(), client.TokenNext()} {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Token", token)
+			if code := serve(pk, r); code != http.StatusOK {
+				t.Fatalf("status %d on the first request after Start", code)
+			}
+		}
+		pk.Close()
+	}
+}
+
+func TestBodyUntouched(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := NewClient(ctx, rfcSecret20)
+	body := strings.Repeat("passkey body ", 1024)
+
+	for name, pk := range map[string]*Server{
+		"header":  NewServer(ctx, rfcSecret20),
+		"sources": NewServer(ctx, rfcSecret20).Sources(QuerySource("token"), CookieSource("token"), BearerSource(), HeaderSource("token")),
+	} {
+		var got string
+		handler := pk.IsValid(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, err := io.ReadAll(r.Body)
+			if err != nil {
+				t.Error(err)
+			}
+			got = string(b)
+		}))
+
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		client.SetHeader(r)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != http.StatusOK || got != body {
+			t.Errorf("%s: status %d read %d of %d body bytes", name, w.Code, len(got), len(body))
+		}
+	}
+}
+
+func TestZeroInterval(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	zero := time.Duration(0)
+
+	server := new(Server)
+	server.Secret(rfcSecret20)
+	server.Interval(&zero)
+	server.Start(ctx)
+
+	client := new(Client)
+	client.Secret(rfcSecret20)
+	if err := client.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := new(CMD)
+	cmd.Interval(&zero)
+
+	// tokens derived before Start use the default
+	unstarted := new(Client)
+	unstarted.Secret(rfcSecret20)
+	unstarted.interval = 0
+
+	for _, pk := range []*PassKey{&server.PassKey, &client.PassKey, &cmd.PassKey} {
+		if pk.interval != time.Minute {
+			t.Errorf("interval %s want 1m", pk.interval)
+		}
+		if d := pk.period(); d <= 0 || d > time.Minute {
+			t.Errorf("period %s", d)
+		}
+	}
+	for name, token := range map[string]string{
+		"client":    client.Token(),
+		"cmd":       cmd.Current(rfcSecret20),
+		"unstarted": unstarted.encode(unstarted.derive(unstarted.loadSecret(), unstarted.now(), 0)),
+	} {
+		if _, err := server.Verify(token); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestTransportRedirect(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, bind := range []bool{false, true} {
+		pk := NewServer(ctx, rfcSecret20)
+		pk.BindRequest(bind)
+		client := NewClient(ctx, rfcSecret20)
+		client.BindRequest(bind)
+
+		var hops []string
+		mux := http.NewServeMux()
+		mux.HandleFunc("/a", func(w http.ResponseWriter, r *http.Request) {
+			hops = append(hops, r.Header.Get("Token"))
+			http.Redirect(w, r, "/b", http.StatusFound)
+		})
+		mux.HandleFunc("/b", func(w http.ResponseWriter, r *http.Request) {
+			hops = append(hops, r.Header.Get("Token"))
+		})
+		ts := httptest.NewServer(pk.IsValid(mux))
+
+		resp, err := (&http.Client{Transport: client.Transport(nil)}).Get(ts.URL + "/a")
+		ts.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || len(hops) != 2 {
+			t.Fatalf("bind %v: status %d after %d hops", bind, resp.StatusCode, len(hops))
+		}
+		if bind && hops[0] == hops[1] {
+			t.Error("bound token of the first hop sent to the second")
+		}
+	}
+}
+
+func TestSecretsEqualUnpadded(t *testing.T) {
+
+	padded := base32.StdEncoding.EncodeToString([]byte("0123456789abcdef"))
+	unpadded := strings.TrimRight(padded, "=")
+	if len(unpadded) != 26 {
+		t.Fatalf("unpadded length %d", len(unpadded))
+	}
+
+	for _, tc := range []struct {
+		a, b  string
+		equal bool
+	}{
+		{padded, unpadded, true},
+		{unpadded, unpadded + "\n", true},
+		{unpadded, rfcSecret20, false},
+		{"JBSWY3DPEHPK3PXP", "JBSWY3DPEHPK3PXP", false}, // 10 bytes; below the minimum key
+		{"invalid!", "invalid!", false},
+	} {
+		if equal := SecretsEqual(tc.a, tc.b); equal != tc.equal {
+			t.Errorf("%q %q: got %v want %v", tc.a, tc.b, equal, tc.equal)
+		}
+	}
+}
+
+func TestFallbackEarlySwitch(t *testing.T) {
+
+	now := time.Unix(60*28000000+25, 0) // five seconds before the rounded boundary
+	client := new(Client)
+	client.Secret(rfcSecret20)
+	client.Interval(nil)
+	client.Clock(func() time.Time { return now })
+	client.EarlySwitch(10 * time.Second)
+	if err := client.Fallback(rfcSecret32); err != nil {
+		t.Fatal(err)
+	}
+
+	primary, fallback, ok := strings.Cut(client.headerValue(), ",")
+	if !ok {
+		t.Fatal("no fallback token")
+	}
+	fb, _ := parseSecret(rfcSecret32)
+	for _, tc := range []struct {
+		name, token string
+		want        uint64
+	}{
+		{"primary", primary, client.derive(client.loadSecret(), now, int(Next))},
+		{"fallback", fallback, client.derive(fb, now, int(Next))},
+	} {
+		v, err := new(Server).decode([]byte(tc.token))
+		if err != nil || v != tc.want {
+			t.Errorf("%s: not the next window token; err %v", tc.name, err)
+		}
+	}
+}
+
+func TestWaitReady(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := new(Server).WaitReady(ctx); err != ErrNotStarted {
+		t.Errorf("never started: got %v want ErrNotStarted", err)
+	}
+
+	fast := 100 * time.Millisecond
+	ticking := new(Server)
+	ticking.Secret(rfcSecret20)
+	ticking.Interval(&fast)
+	ticking.Start(ctx)
+	wait, stop := context.WithTimeout(ctx, 10*fast)
+	defer stop()
+	if err := ticking.WaitReady(wait); err != nil {
+		t.Errorf("ticking generator: %v", err)
+	}
+	if err := ticking.WaitReady(wait); err != nil {
+		t.Errorf("after the first tick: %v", err)
+	}
+
+	slow := NewServer(ctx, rfcSecret20)
+	done, abort := context.WithCancel(ctx)
+	abort()
+	if err := slow.WaitReady(done); err != context.Canceled {
+		t.Errorf("cancelled wait: got %v want context.Canceled", err)
+	}
+}
@@ -0,0 +1,238 @@
+package passkey
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+/*
+
+	PERSIST
+	saves and restores server state across restarts; the token set is
+	derived from the secret and the clock so a restarted generator
+	matches clients without persistence, but the rotation secrets with
+	their disabled state, the pending rotation notice, and the
+	OncePerInterval cache are lost unless saved to a Store
+
+	pk.Persist(store)
+	pk.Start(ctx)
+	pk.Load()        // restore on startup
+	defer pk.Close() // save on graceful shutdown
+
+	a graceful shutdown through Close saves the current state; after a
+	hard crash only the state of the last explicit Save survives so call
+	Save periodically when that gap matters; the saved state includes
+	rotation secrets so the store must be protected like the secret
+
+*/
+
+// Store persists named state across restarts
+type Store interface {
+	Load(name string) ([]byte, error) // ErrNotFound when never saved
+	Save(name string, b []byte) error
+}
+
+// Persister is implemented by stateful caches whose state is saved to the
+// Store under a name
+type Persister interface {
+	Snapshot() ([]byte, error)
+	Restore(b []byte) error
+}
+
+// ErrNotFound is returned by a Store when the named state was never saved
+var ErrNotFound = errors.New("passkey: state not found")
+
+// DirStore is a Store keeping each named state in a 0600 file in a directory
+type DirStore string
+
+// Load reads the named state file
+func (d DirStore) Load(name string) ([]byte, error) {
+	b, err := os.ReadFile(filepath.Join(string(d), name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return b, err
+}
+
+// Save atomically replaces the named state file
+func (d DirStore) Save(name string, b []byte) error {
+
+	f, err := os.CreateTemp(string(d), "."+name+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), filepath.Join(string(d), name))
+}
+
+// snapshotName is the store name of the server state
+const snapshotName = "passkey"
+
+// Persist sets the Store used by Save, Load, and Close to keep the rotation
+// secrets, the pending rotation notice, and the OncePerInterval cache across
+// restarts; a store that implements io.Closer is closed by Close
+func (pk *Server) Persist(store Store) *Server {
+
+	pk.store = store
+	if c, ok := store.(io.Closer); ok {
+		pk.stores = append(pk.stores, c)
+	}
+
+	return pk
+}
+
+// register a named Persister saved and restored with the server state
+func (pk *Server) register(name string, p Persister) {
+	if pk.persist == nil {
+		pk.persist = make(map[string]Persister)
+	}
+	pk.persist[name] = p
+}
+
+// Save writes the server state and the registered caches to the Store
+func (pk *Server) Save() error {
+
+	if pk.store == nil {
+		return nil
+	}
+
+	b, err := pk.Snapshot()
+	if err != nil {
+		return err
+	}
+	if err := pk.store.Save(snapshotName, b); err != nil {
+		return err
+	}
+
+	for name, p := range pk.persist {
+		b, err := p.Snapshot()
+		if err != nil {
+			return err
+		}
+		if err := pk.store.Save(name, b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Load restores the server state and the registered caches from the Store;
+// state that was never saved is skipped
+func (pk *Server) Load() error {
+
+	if pk.store == nil {
+		return nil
+	}
+
+	b, err := pk.store.Load(snapshotName)
+	switch err {
+	case nil:
+		if err := pk.Restore(b); err != nil {
+			return err
+		}
+	case ErrNotFound:
+	default:
+		return err
+	}
+
+	for name, p := range pk.persist {
+		b, err := pk.store.Load(name)
+		switch err {
+		case nil:
+			if err := p.Restore(b); err != nil {
+				return err
+			}
+		case ErrNotFound:
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// snapshot is the persisted server state
+type snapshot struct {
+	Keys     []snapshotKey `json:"keys,omitempty"`
+	Rotation string        `json:"rotation,omitempty"`
+	Disabled string        `json:"disabled,omitempty"` // fingerprint of the disabled primary secret
+}
+
+// snapshotKey is a persisted rotation secret
+type snapshotKey struct {
+	Secret   []byte    `json:"secret"`
+	Added    time.Time `json:"added"`
+	Disabled bool      `json:"disabled,omitempty"`
+}
+
+// Snapshot returns the server rotation state; the additional rotation
+// secrets, any pending rotation notice, and the disabled state of every
+// secret so a DisableSecret kill switch survives a restart; a derived
+// server snapshots the state of its parent
+func (pk *Server) Snapshot() ([]byte, error) {
+
+	g := pk.gen()
+
+	var s snapshot
+	if keys := g.keys.Load(); keys != nil {
+		for _, k := range *keys {
+			s.Keys = append(s.Keys, snapshotKey{Secret: k.secret, Added: k.added, Disabled: k.disabled.Load()})
+		}
+	}
+	if g.disabled.Load() {
+		s.Disabled = fingerprint(g.loadSecret())
+	}
+	if notice := g.rotation.Load(); notice != nil {
+		s.Rotation = *notice
+	}
+
+	return json.Marshal(s)
+}
+
+// Restore replaces the server rotation state with a Snapshot; the primary
+// secret stays disabled only when it is still the secret that was disabled
+// so a restart with a replaced secret does not lock out every client
+func (pk *Server) Restore(b []byte) error {
+
+	var s snapshot
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	keys := make([]*key, 0, len(s.Keys))
+	for _, k := range s.Keys {
+		if len(k.Secret) < minKey || len(k.Secret) > maxSecret {
+			return errors.New("passkey: restored secret size out of range")
+		}
+		r := &key{secret: k.Secret, added: k.Added}
+		r.disabled.Store(k.Disabled)
+		keys = append(keys, r)
+	}
+
+	g := pk.gen()
+	g.keys.Store(&keys)
+	g.disabled.Store(len(s.Disabled) > 0 && s.Disabled == fingerprint(g.loadSecret()))
+
+	if len(s.Rotation) > 0 {
+		g.rotation.Store(&s.Rotation)
+	} else {
+		g.rotation.Store(nil)
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package passkey
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPersistDisabled(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := DirStore(t.TempDir())
+	pk := NewServer(ctx, rfcSecret20).Persist(store)
+	if err := pk.AddSecret(rfcSecret32); err != nil {
+		t.Fatal(err)
+	}
+	rotated := NewClient(ctx, rfcSecret32)
+	primary := NewClient(ctx, rfcSecret20)
+
+	if !pk.DisableSecret(rotated.Fingerprint()) || !pk.DisableSecret(primary.Fingerprint()) {
+		t.Fatal("secret not found")
+	}
+	if err := pk.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewServer(ctx, rfcSecret20).Persist(store)
+	defer restored.Close()
+	if err := restored.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, info := range restored.Secrets() {
+		if !info.Disabled {
+			t.Errorf("secret %s enabled after restore", info.ID)
+		}
+	}
+	for _, client := range []*Client{rotated, primary} {
+		if _, err := restored.Verify(client.Token()); err != ErrUnauthorized {
+			t.Errorf("disabled secret %s: got %v", client.Fingerprint(), err)
+		}
+	}
+
+	restored.EnableSecret(rotated.Fingerprint())
+	if _, err := restored.Verify(rotated.Token()); err != nil {
+		t.Errorf("enabled secret: %v", err)
+	}
+}
+
+func TestPersistReplacedPrimary(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := DirStore(t.TempDir())
+	pk := NewServer(ctx, rfcSecret20).Persist(store)
+	if !pk.DisableSecret(pk.Fingerprint()) {
+		t.Fatal("primary not found")
+	}
+	if err := pk.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	replaced := NewServer(ctx, rfcSecret32).Persist(store)
+	defer replaced.Close()
+	if err := replaced.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := replaced.Verify(replaced.Token()); err != nil {
+		t.Errorf("replaced primary disabled after restore: %v", err)
+	}
+
+	same := NewServer(ctx, rfcSecret20).Persist(store)
+	defer same.Close()
+	if err := same.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := same.Verify(same.Token()); err != ErrUnauthorized {
+		t.Errorf("disabled primary after restore: got %v", err)
+	}
+}
+
+func TestPersistDerived(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pk := NewServer(ctx, rfcSecret20)
+	if err := pk.AddSecret(rfcSecret32); err != nil {
+		t.Fatal(err)
+	}
+	b, err := pk.Derive().Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewServer(ctx, rfcSecret20)
+	derived := restored.Derive()
+	if err := derived.Restore(b); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(restored.Secrets()); n != 2 {
+		t.Errorf("parent secrets after derived restore: got %d want 2", n)
+	}
+}
+
+func TestRestoreSecretSize(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pk := NewServer(ctx, rfcSecret20)
+	for _, b := range []string{
+		`{"keys":[{"secret":"c2hvcnQ=","added":"2024-01-01T00:00:00Z"}]}`,
+		`{"keys":[{"secret":"","added":"2024-01-01T00:00:00Z"}]}`,
+	} {
+		if err := pk.Restore([]byte(b)); err == nil {
+			t.Errorf("%s: restored", b)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package pktest
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/zxdev/passkey"
+)
+
+/*
+
+	PKTEST
+	helpers for testing handlers behind passkey.Server.IsValid without
+	running the interval generator or computing tokens by hand; kept
+	in a separate package so it is not compiled into production binaries
+
+	pk := pktest.NewServer("PASSKEYXXBASE32XXSECRETXXEXAMPLE")
+	req := httptest.NewRequest("GET", "/hello", nil)
+	req.Header = pktest.ValidHeader(pk, req, "")
+	pk.IsValid(handler).ServeHTTP(rec, req)
+
+*/
+
+// NewServer returns a passkey.Server using the secret with a clock frozen at
+// the current time; the token set is generated without the interval generator
+// so tokens from ValidHeader remain valid for the life of the test
+func NewServer(secret string) *passkey.Server {
+
+	now := time.Now()
+	pk := new(passkey.Server)
+	pk.Secret(secret)
+	pk.Clock(func() time.Time { return now })
+
+	// a cancelled context generates the token set and stops the generator
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	pk.Start(ctx)
+
+	return pk
+}
+
+// ValidHeader returns an http.Header with a currently valid token for pk
+// and the request r set on the header key as Client.SetHeader sets it, with
+// the version of StructuredHeader mode and bound to the method and path of r
+// in BindRequest mode; pass an empty key for the pk configured header key
+func ValidHeader(pk *passkey.Server, r *http.Request, key string) http.Header {
+
+	if len(key) == 0 {
+		key = pk.HeaderKey()
+	}
+
+	h := make(http.Header)
+	h.Set(key, pk.RequestToken(r))
+	return h
+}
@@ -0,0 +1,46 @@
+package pktest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestValidHeader(t *testing.T) {
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, tc := range []struct {
+		name       string
+		structured bool
+		bind       bool
+	}{
+		{"plain", false, false},
+		{"structured", true, false},
+		{"bound", false, true},
+		{"structured bound", true, true},
+	} {
+		pk := NewServer("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ")
+		pk.StructuredHeader(tc.structured)
+		pk.BindRequest(tc.bind)
+
+		req := httptest.NewRequest(http.MethodPost, "/orders", nil)
+		req.Header = ValidHeader(pk, req, "")
+		rec := httptest.NewRecorder()
+		pk.IsValid(next).ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: status %d want %d", tc.name, rec.Code, http.StatusOK)
+		}
+
+		if !tc.bind {
+			continue
+		}
+		other := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		other.Header = req.Header
+		rec = httptest.NewRecorder()
+		pk.IsValid(next).ServeHTTP(rec, other)
+		if rec.Code == http.StatusOK {
+			t.Errorf("%s: token bound to POST accepted for GET", tc.name)
+		}
+	}
+}
@@ -0,0 +1,420 @@
+package passkey
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+/*
+
+	ROTATION
+	additional secrets accepted by the server while clients migrate
+	from one shared secret to another; the secret set is a copy-on-write
+	slice behind an atomic.Pointer so validation reads a stable snapshot
+	without locking while secrets are added or removed concurrently
+
+	for a tightly coupled client and server the server may push the
+	new secret to clients sealed under the current secret in the
+	Passkey-Rotate response header; RotateAndNotify and ApplyRotation
+
+*/
+
+// key is an additional secret accepted by the server
+type key struct {
+	secret   []byte      // binary secret
+	added    time.Time   // time the secret was added
+	disabled atomic.Bool // rejected but kept for audit; see DisableSecret
+}
+
+// AddSecret adds an additional secret the server accepts alongside the
+// configured secret during rotation; accepts the same forms as Secret
+func (pk *Server) AddSecret(secret interface{}) error {
+
+	b, ok := parseSecret(secret)
+	if !ok {
+		return ErrSecretSize
+	}
+	pk.gen().addKey(b)
+
+	return nil
+}
+
+// addKey adds the binary secret to the rotation set and returns its key
+func (pk *Server) addKey(b []byte) *key {
+
+	k := &key{secret: b, added: pk.now()}
+	for {
+		current := pk.keys.Load()
+		var next []*key
+		if current != nil {
+			next = append(next, *current...)
+		}
+		next = append(next, k)
+		if pk.keys.CompareAndSwap(current, &next) {
+			return k
+		}
+	}
+}
+
+// RemoveSecret removes an additional secret added by AddSecret and reports
+// whether it was found; accepts the same forms as Secret; a pending rotation
+// notice sealed under the removed secret is cleared
+func (pk *Server) RemoveSecret(secret interface{}) bool {
+
+	b, ok := parseSecret(secret)
+	if !ok {
+		return false
+	}
+
+	pk = pk.gen()
+	for {
+		current := pk.keys.Load()
+		if current == nil {
+			return false
+		}
+
+		var found bool
+		next := make([]*key, 0, len(*current))
+		for _, k := range *current {
+			if subtle.ConstantTimeCompare(k.secret, b) == 1 {
+				found = true
+				continue
+			}
+			next = append(next, k)
+		}
+		if !found {
+			return false
+		}
+		if pk.keys.CompareAndSwap(current, &next) {
+			if notice := pk.rotation.Load(); notice != nil {
+				if _, err := open(b, *notice); err == nil {
+					pk.rotation.CompareAndSwap(notice, nil)
+				}
+			}
+			return true
+		}
+	}
+}
+
+// SecretInfo describes an accepted secret without exposing it
+type SecretInfo struct {
+	ID       string    `json:"id"`       // one-way fingerprint; see String
+	Primary  bool      `json:"primary"`  // the secret tokens are generated with
+	Added    time.Time `json:"added"`    // time the secret was set or added
+	Disabled bool      `json:"disabled"` // rejected; see DisableSecret
+}
+
+// Secrets returns the accepted secrets, the primary secret first followed by
+// the additional rotation secrets in the order added, for an admin view of
+// the rotation state; the secret material is never exposed
+func (pk *Server) Secrets() []SecretInfo {
+
+	pk = pk.gen()
+	secrets := []SecretInfo{{ID: fingerprint(pk.loadSecret()), Primary: true, Disabled: pk.disabled.Load()}}
+	if n := pk.stored.Load(); n > 0 {
+		secrets[0].Added = time.Unix(0, n).UTC()
+	}
+	if keys := pk.keys.Load(); keys != nil {
+		for _, k := range *keys {
+			secrets = append(secrets, SecretInfo{ID: fingerprint(k.secret), Added: k.added.UTC(), Disabled: k.disabled.Load()})
+		}
+	}
+
+	return secrets
+}
+
+// DisableSecret rejects the accepted secret with the fingerprint, the
+// primary secret or a rotation secret, so Verify never matches it while it
+// stays listed by Secrets for audit; an immediate kill switch for a leaked
+// secret ahead of a full rotation; a request presenting a token of a
+// disabled secret is logged as a warning; reports whether it was found
+func (pk *Server) DisableSecret(fingerprint string) bool {
+	return pk.gen().setDisabled(fingerprint, true)
+}
+
+// EnableSecret accepts a secret disabled by DisableSecret again; reports
+// whether it was found
+func (pk *Server) EnableSecret(fingerprint string) bool {
+	return pk.gen().setDisabled(fingerprint, false)
+}
+
+// setDisabled sets the disabled state of the secret with the fingerprint
+func (pk *Server) setDisabled(id string, disabled bool) bool {
+
+	var found bool
+	if id == fingerprint(pk.loadSecret()) {
+		pk.disabled.Store(disabled)
+		found = true
+	}
+	if keys := pk.keys.Load(); keys != nil {
+		for _, k := range *keys {
+			if id == fingerprint(k.secret) {
+				k.disabled.Store(disabled)
+				found = true
+			}
+		}
+	}
+	if found && pk.log != nil {
+		outcome := "enabled"
+		if disabled {
+			outcome = "disabled"
+		}
+		pk.log.Warn("passkey: secret "+outcome, "outcome", outcome, "secret", id)
+	}
+
+	return found
+}
+
+// presented logs a token value matching a disabled secret; derives the
+// accepted windows of each disabled secret so it costs nothing unless a
+// secret is disabled and a logger is set
+func (pk *Server) presented(v uint64, t time.Time, scope string) {
+
+	if pk.log == nil {
+		return
+	}
+
+	g := pk.gen()
+	var disabled [][]byte
+	if g.disabled.Load() {
+		disabled = append(disabled, g.loadSecret())
+	}
+	if keys := g.keys.Load(); keys != nil {
+		for _, k := range *keys {
+			if k.disabled.Load() {
+				disabled = append(disabled, k.secret)
+			}
+		}
+	}
+
+	past, future := pk.skew()
+	for _, secret := range disabled {
+		for w := Window(-past); w <= Window(future); w++ {
+			if pk.accepts(w) && v == pk.deriveScope(secret, t, int(w), scope) {
+				pk.log.Warn("passkey: disabled secret presented", "outcome", "disabled", "secret", fingerprint(secret), "window", w.String())
+				return
+			}
+		}
+	}
+}
+
+// matchKeys returns the window of the token value valid at time t for any
+// additional secret; each secret costs one HMAC per window and every window
+// of every enabled secret is compared in constant time
+func (pk *Server) matchKeys(v uint64, t time.Time, scope string) (Window, bool) {
+
+	keys := pk.gen().keys.Load()
+	if keys == nil {
+		return 0, false
+	}
+
+	var m matcher
+	past, future := pk.skew()
+	for _, k := range *keys {
+		if k.disabled.Load() {
+			continue
+		}
+		for w := Window(-past); w <= Window(future); w++ {
+			if pk.accepts(w) {
+				m.add(w, v, pk.deriveScope(k.secret, t, int(w), scope), true)
+			}
+		}
+	}
+
+	return m.result()
+}
+
+// CostPerVerify returns the number of HMAC computations a single Verify
+// performs in the worst case under the current settings; windows of the
+// generator set and past ring cost none, accepted skew windows beyond those
+// cost one HMAC each for every token, every accepted
+// window costs one HMAC in BindRequest mode where the token set depends on
+// the request, each enabled rotation secret costs one HMAC per accepted
+// window, ExpiredStatus costs one per expired window checked, and with a
+// Logger each disabled secret costs one per accepted window; a request
+// verified against a WithSecret context secret costs one HMAC per accepted
+// window instead
+func (pk *Server) CostPerVerify() int {
+
+	g := pk.gen()
+	past, future := pk.skew()
+
+	var accepted int
+	for w := Window(-past); w <= Window(future); w++ {
+		if pk.accepts(w) {
+			accepted++
+		}
+	}
+
+	var cost, disabled int
+	switch {
+	case g.disabled.Load():
+		disabled++ // only the rotation secrets are matched
+	case pk.bind:
+		cost += accepted
+	default:
+		for w := Window(-past); w <= Window(future); w++ {
+			if w >= Previous && w <= Next || !pk.accepts(w) {
+				continue
+			}
+			if _, ok := g.ringAt(w); !ok {
+				cost++
+			}
+		}
+	}
+
+	// expired windows are derived for the primary secret on a miss
+	if pk.expired != 0 && !g.disabled.Load() {
+		for w := Window(-past - 1); w < Current; w++ {
+			if !pk.accepts(w) {
+				cost++
+			}
+		}
+	}
+
+	if keys := g.keys.Load(); keys != nil {
+		for _, k := range *keys {
+			if k.disabled.Load() {
+				disabled++
+				continue
+			}
+			cost += accepted
+		}
+	}
+
+	// a miss is checked against each disabled secret for the log
+	if pk.log != nil {
+		cost += disabled * accepted
+	}
+
+	return cost
+}
+
+// RotateHeader is the response header carrying a sealed rotation notice
+const RotateHeader = "Passkey-Rotate"
+
+// ErrRotation is returned when a rotation notice can not be opened
+var ErrRotation = errors.New("passkey: invalid rotation notice")
+
+// RotateAndNotify rotates the server to the new secret while still accepting
+// the current secret as an additional secret and sets a rotation notice on
+// every authenticated response; the new secret is sealed with AES-GCM keyed
+// by a KDF of the current secret so only clients holding the current secret
+// can apply it; remove the prior secret with RemoveSecret once clients rotate,
+// which also clears the notice; see ClearRotation
+func (pk *Server) RotateAndNotify(newSecret interface{}) error {
+
+	b, ok := parseSecret(newSecret)
+	if !ok {
+		return ErrSecretSize
+	}
+
+	pk = pk.gen()
+	current := pk.loadSecret()
+	notice, err := seal(current, b)
+	if err != nil {
+		return err
+	}
+
+	k := pk.addKey(current)
+	if pk.disabled.Swap(false) {
+		k.disabled.Store(true) // the disabled state follows the prior secret
+	}
+	pk.storeSecret(b)
+	pk.regenerate()
+	pk.rotation.Store(&notice)
+	if pk.log != nil {
+		pk.log.Info("passkey: rotated", "outcome", "rotated", "secret", fingerprint(b), "prior", fingerprint(current))
+	}
+
+	return nil
+}
+
+// ClearRotation stops sending the pending rotation notice set by
+// RotateAndNotify once clients have rotated; RemoveSecret of the prior
+// secret also clears it
+func (pk *Server) ClearRotation() {
+	pk.gen().rotation.Store(nil)
+}
+
+// ApplyRotation opens the rotation notice on the response and swaps to the
+// new secret; fails closed with ErrRotation keeping the current secret when
+// the notice can not be opened and returns nil when there is no notice
+func (pk *Client) ApplyRotation(resp *http.Response) error {
+
+	notice := resp.Header.Get(RotateHeader)
+	if len(notice) == 0 {
+		return nil
+	}
+
+	b, err := open(pk.loadSecret(), notice)
+	if err != nil {
+		return ErrRotation
+	}
+	if subtle.ConstantTimeCompare(b, pk.loadSecret()) == 1 {
+		return nil // already applied
+	}
+
+	pk.storeSecret(b)
+	pk.regenerate()
+
+	return nil
+}
+
+// rotationAEAD returns the AES-256-GCM cipher keyed by a KDF of the secret
+func rotationAEAD(secret []byte) (cipher.AEAD, error) {
+
+	kdf := hmac.New(sha256.New, secret)
+	kdf.Write([]byte("passkey rotation"))
+	block, err := aes.NewCipher(kdf.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// seal the new secret under the current secret; nonce|ciphertext base32
+func seal(current, secret []byte) (string, error) {
+
+	aead, err := rotationAEAD(current)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	return rawEncoding.EncodeToString(aead.Seal(nonce, nonce, secret, nil)), nil
+}
+
+// open the sealed secret with the current secret
+func open(current []byte, notice string) ([]byte, error) {
+
+	aead, err := rotationAEAD(current)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := rawEncoding.DecodeString(notice)
+	if err != nil || len(b) < aead.NonceSize() {
+		return nil, ErrRotation
+	}
+
+	secret, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], nil)
+	if err != nil || len(secret) < minKey || len(secret) > maxSecret {
+		return nil, ErrRotation
+	}
+
+	return secret, nil
+}
@@ -0,0 +1,136 @@
+package passkey
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestRotationCleared(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pk := NewServer(ctx, rfcSecret20)
+	client := NewClient(ctx, rfcSecret20)
+	handler := pk.IsValid(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func() *http.Response {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		client.SetHeader(r)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %d", w.Code)
+		}
+		return w.Result()
+	}
+
+	if err := pk.RotateAndNotify(rfcSecret32); err != nil {
+		t.Fatal(err)
+	}
+	resp := serve()
+	if resp.Header.Get(RotateHeader) == "" {
+		t.Fatal("no rotation notice")
+	}
+	if err := client.ApplyRotation(resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if !pk.RemoveSecret(rfcSecret20) {
+		t.Fatal("prior secret not found")
+	}
+	resp = serve()
+	if notice := resp.Header.Get(RotateHeader); notice != "" {
+		t.Fatalf("notice sealed under the removed secret: %s", notice)
+	}
+	if err := client.ApplyRotation(resp); err != nil {
+		t.Fatal(err)
+	}
+
+	pk.RotateAndNotify(rfcSecret64)
+	pk.ClearRotation()
+	if pk.rotation.Load() != nil {
+		t.Fatal("ClearRotation kept the notice")
+	}
+}
+
+func TestCostPerVerify(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, tc := range []struct {
+		name      string
+		configure func(*Server)
+		cost      int
+	}{
+		{"default", func(pk *Server) {}, 0},
+		{"bind", func(pk *Server) { pk.BindRequest(true) }, 3},
+		{"expired", func(pk *Server) { pk.ExpiredStatus(StatusExpired) }, 1},
+		{"future", func(pk *Server) { pk.FutureSkew(3) }, 2},
+		{"rotation", func(pk *Server) { pk.AddSecret(rfcSecret32) }, 3},
+		{"bind rotation expired", func(pk *Server) {
+			pk.BindRequest(true)
+			pk.AddSecret(rfcSecret32)
+			pk.ExpiredStatus(StatusExpired)
+		}, 7},
+	} {
+		pk := NewServer(ctx, rfcSecret20)
+		tc.configure(pk)
+		if cost := pk.CostPerVerify(); cost != tc.cost {
+			t.Errorf("%s: cost %d want %d", tc.name, cost, tc.cost)
+		}
+	}
+}
+
+func TestRotationRace(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pk := NewServer(ctx, rfcSecret20)
+	client := NewClient(ctx, rfcSecret20)
+	rotated := NewClient(ctx, rfcSecret32)
+	pk.AddSecret(rfcSecret32)
+
+	done := make(chan struct{})
+	var rotate sync.WaitGroup
+	rotate.Add(1)
+	go func() {
+		defer rotate.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			secret, _ := GenerateSecret(0)
+			pk.AddSecret(secret)
+			pk.Secrets()
+			pk.RemoveSecret(secret)
+		}
+	}()
+
+	var verify sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		verify.Add(1)
+		go func() {
+			defer verify.Done()
+			for j := 0; j < 500; j++ {
+				for _, c := range []*Client{client, rotated} {
+					if _, err := pk.Verify(c.Token()); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	verify.Wait()
+	close(done)
+	rotate.Wait()
+}
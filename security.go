@@ -0,0 +1,136 @@
+package passkey
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+/*
+
+	SECURITY
+	advisory introspection of the guessing difficulty of a configuration
+	for security reviews; pure computation over the settings
+
+	bits, notes := pk.SecurityLevel()
+
+	a native token is 64 bits of HMAC output and a TOTP code is digits
+	decimal places; every value accepted at once, each window and each
+	rotation secret, is one more target for a single guess so the
+	effective entropy is the token entropy less log2 of the accepted
+	values; the secret size caps it
+
+*/
+
+// longInterval is the interval above which a captured token stays useful
+// long enough to be flagged
+const longInterval = 10 * time.Minute
+
+// SecurityLevel reports the effective guessing entropy in bits of a single
+// guess against the configured token format, skew windows, and secret, with
+// notes flagging risky settings; advisory only
+func (pk *PassKey) SecurityLevel() (bitsEffective float64, notes []string) {
+	past, future := pk.skew()
+	return pk.securityLevel(pk.loadSecret(), pk.generated, past+future+1, 1)
+}
+
+// SecurityLevel reports the effective guessing entropy in bits of a single
+// guess against the server, counting the accepted windows and rotation
+// secrets, with notes flagging risky settings including the absence of
+// RejectDelay or OncePerInterval rate limiting; advisory only
+func (pk *Server) SecurityLevel() (bitsEffective float64, notes []string) {
+
+	past, future := pk.skew()
+	windows := 0
+	for w := Window(-past); w <= Window(future); w++ {
+		if pk.accepts(w) {
+			windows++
+		}
+	}
+
+	secrets := 1
+	if keys := pk.gen().keys.Load(); keys != nil {
+		secrets += len(*keys)
+	}
+
+	g := pk.gen()
+	bitsEffective, notes = pk.securityLevel(g.loadSecret(), g.generated, windows, secrets)
+	if secrets > 1 {
+		notes = append(notes, fmt.Sprintf("%d secrets accepted during rotation; remove the prior secret once clients rotate", secrets))
+	}
+
+	limited := pk.delay > 0 || pk.onceKey != nil
+	if !limited {
+		notes = append(notes, "no rate limiting; online guessing is bounded only by request throughput; see RejectDelay and OncePerInterval")
+		if windows > 3 && pk.counter == nil && pk.interval > longInterval {
+			notes = append(notes, "risky: wide window and long interval without rate limiting")
+		}
+	}
+	if pk.digits > 0 && !limited {
+		notes = append(notes, "risky: numeric codes without rate limiting")
+	}
+	if len(pk.trusted) > 0 {
+		notes = append(notes, fmt.Sprintf("%d trusted ranges bypass the token; see TrustedCIDRs", len(pk.trusted)))
+	}
+	if pk.dryRun {
+		notes = append(notes, "risky: dry run authorizes every request; see DryRun")
+	}
+	if pk.debugWindow {
+		notes = append(notes, "401 responses disclose the server window; disable DebugWindow in production")
+	}
+	if !pk.requireTLS {
+		notes = append(notes, "plaintext requests accepted; tokens can be captured in transit; see RequireTLS")
+	}
+
+	return bitsEffective, notes
+}
+
+// securityLevel computes the effective entropy of the secret for the number
+// of windows and secrets accepted at once and the notes common to client and
+// server
+func (pk *PassKey) securityLevel(secret []byte, generated bool, windows, secrets int) (float64, []string) {
+
+	var notes []string
+
+	bits := 64.0
+	if pk.digits > 0 {
+		bits = float64(pk.digits) * math.Log2(10)
+		notes = append(notes, fmt.Sprintf("TOTP codes of %d digits; %.1f bits", pk.digits, bits))
+	}
+
+	if len(secret) > 0 {
+		if keyBits := float64(8 * len(secret)); keyBits < bits {
+			bits = keyBits
+		}
+		if len(secret) < minSecret {
+			notes = append(notes, fmt.Sprintf("secret of %d bits is below the %d bit generated size", 8*len(secret), 8*minSecret))
+		}
+	}
+	if generated {
+		notes = append(notes, "secret was generated; clients can not match it")
+	}
+
+	if windows < 1 {
+		windows = 1
+		notes = append(notes, "no window is accepted; every token is rejected")
+	}
+	valid := windows * secrets
+	bits -= math.Log2(float64(valid))
+	if valid > 1 {
+		notes = append(notes, fmt.Sprintf("%d values valid at once cost %.1f bits", valid, math.Log2(float64(valid))))
+	}
+	if windows > 3 {
+		notes = append(notes, fmt.Sprintf("%d windows accepted; wider than the default 3", windows))
+	}
+
+	switch {
+	case pk.counter != nil:
+		notes = append(notes, "counter mode; a token stays valid until the counter advances")
+	case pk.interval > 0:
+		if life := time.Duration(windows) * pk.interval; pk.interval > longInterval {
+			notes = append(notes, fmt.Sprintf("interval %v keeps a captured token valid for up to %v", pk.interval, life))
+		}
+	}
+
+	return bits, notes
+}
@@ -0,0 +1,69 @@
+package passkey
+
+import (
+	"net/http"
+	"strings"
+)
+
+/*
+
+	SOURCES
+	ordered token extractors for gateways that accept the token from
+	more than one place; the first non-empty extraction is used
+
+	pk.Sources(
+		passkey.HeaderSource("token"),
+		passkey.BearerSource(),
+		passkey.CookieSource("passkey"),
+		passkey.QuerySource("token"),
+	)
+
+*/
+
+// TokenSource extracts a token from the request or returns an empty string;
+// a source must not read the request body, which IsValid leaves to next
+type TokenSource func(r *http.Request) string
+
+// Sources sets the ordered token sources tried by IsValid in place of the
+// header keys; pass none to restore the header keys
+func (pk *Server) Sources(sources ...TokenSource) *Server {
+	pk.sources = sources
+	return pk
+}
+
+// HeaderSource extracts the token from the request header key
+func HeaderSource(key string) TokenSource {
+	return func(r *http.Request) string {
+		return r.Header.Get(key)
+	}
+}
+
+// BearerSource extracts the token from an Authorization: Bearer {token} header
+func BearerSource() TokenSource {
+	return func(r *http.Request) string {
+		scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			return ""
+		}
+		return strings.TrimSpace(token)
+	}
+}
+
+// CookieSource extracts the token from the named cookie
+func CookieSource(name string) TokenSource {
+	return func(r *http.Request) string {
+		c, err := r.Cookie(name)
+		if err != nil {
+			return ""
+		}
+		return c.Value
+	}
+}
+
+// QuerySource extracts the token from the named URL query parameter; tokens
+// in a URL are easily logged so prefer the header sources where possible
+func QuerySource(param string) TokenSource {
+	return func(r *http.Request) string {
+		return r.URL.Query().Get(param)
+	}
+}
@@ -0,0 +1,184 @@
+package passkey
+
+import (
+	"crypto/hmac"
+	"encoding/binary"
+	"strconv"
+	"time"
+)
+
+/*
+
+	TOTP
+	RFC 6238 compatible numeric codes so a key shared with an authenticator
+	app produces matching codes; the native scheme stays the default and
+	this mode replaces it for both generation and verification
+
+	message  big-endian uint64 of the unix seconds divided by the interval,
+	         or of the Counter value for RFC 4226 HOTP
+	hash     HMAC of the message keyed by the binary secret
+	offset   hash[len(hash)-1] & 0xf; RFC 4226 dynamic truncation
+	code     big-endian uint32 of hash[offset:offset+4] with the high bit
+	         masked, modulo 10^digits, zero padded to digits
+
+	pk.Secret("JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP").TOTP(6).Interval(&thirty)
+
+	codes carry no obfuscation bytes and are not bound to a request; the
+	server middleware reads the decimal code in place of the base32 token
+	and rejects a code of another length or with a non-digit with 400;
+	see RFCVectors for the RFC 6238 test vectors
+
+*/
+
+// TOTP sets the RFC 6238 compatible mode where tokens are numeric codes of
+// 6 through 8 digits derived with the RFC 4226 dynamic truncation; use a 30
+// second interval to match authenticator apps; client and server must agree;
+// out of range values are clamped with a warning
+//
+//	pass 0 for the native format; default
+func (pk *PassKey) TOTP(digits int) *PassKey {
+
+	switch {
+	case digits < 0:
+		warn("negative TOTP digits %d; using the native format", digits)
+		digits = 0
+	case digits > 0 && digits < 6:
+		warn("TOTP digits %d below 6; using 6", digits)
+		digits = 6
+	case digits > 8:
+		warn("TOTP digits %d exceeds 8; using 8", digits)
+		digits = 8
+	}
+	pk.digits = digits
+
+	return pk
+}
+
+// pow10 is the code modulus for each number of digits
+var pow10 = [...]uint32{1, 10, 100, 1000, 10000, 100000, 1000000, 10000000, 100000000}
+
+// code derives the RFC 6238 code for the secret and interval offset relative
+// to the reference time t, or the RFC 4226 code for the Counter value
+func (pk *PassKey) code(secret []byte, t time.Time, offset int) uint64 {
+
+	var msg [8]byte
+	if pk.counter != nil {
+		binary.BigEndian.PutUint64(msg[:], pk.counter()+uint64(offset))
+	} else {
+		step := int64(pk.step() / time.Second)
+		binary.BigEndian.PutUint64(msg[:], uint64(t.Unix()/step+int64(offset)))
+	}
+
+	sign := hmac.New(pk.algorithm.hash(), secret)
+	sign.Write(msg[:])
+	hash := sign.Sum(nil)
+
+	o := hash[len(hash)-1] & 0xf
+	v := binary.BigEndian.Uint32(hash[o:o+4]) & 0x7fffffff
+
+	return uint64(v % pow10[pk.digits])
+}
+
+// step returns the RFC 6238 time step; the interval in whole seconds and at
+// least one second
+func (pk *PassKey) step() time.Duration {
+	if step := pk.interval.Truncate(time.Second); step > 0 {
+		return step
+	}
+	return time.Second
+}
+
+// encodeCode returns the code zero padded to the configured digits
+func (pk *PassKey) encodeCode(v uint64) string {
+
+	var buf [8]byte
+	b := strconv.AppendUint(buf[:0], v, 10)
+	for len(b) < pk.digits {
+		b = append([]byte{'0'}, b...)
+	}
+
+	return string(b)
+}
+
+// parseCode returns the value of a numeric code of the configured digits;
+// ErrWrongLength for another length and ErrMalformed for a non-digit; every
+// character is read so the work does not depend on where a bad digit is and
+// the value is matched as a fixed width integer in constant time
+func (pk *PassKey) parseCode(token []byte) (uint64, error) {
+
+	if len(token) != pk.digits {
+		return 0, ErrWrongLength
+	}
+
+	var v uint64
+	var bad byte
+	for _, c := range token {
+		d := c - '0'
+		bad |= ^byte((uint64(d)-10)>>63) & 1 // set unless d is 0 through 9
+		v = v*10 + uint64(d)
+	}
+	if bad != 0 {
+		return 0, ErrMalformed
+	}
+
+	return v, nil
+}
+
+// RFCVector is an RFC 6238 appendix B test vector for the TOTP mode with
+// a 30 second interval and 8 digits
+type RFCVector struct {
+	Secret    string    // base32 encoded secret
+	Algorithm Algorithm // hmac hash
+	Time      time.Time // reference time
+	Code      string    // 8-digit code
+}
+
+// RFC 6238 appendix B secrets; the ASCII digits repeated to 20, 32, and 64 bytes
+const (
+	rfcSecret20 = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	rfcSecret32 = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZA===="
+	rfcSecret64 = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNA="
+)
+
+// rfcVectors are the RFC 6238 appendix B test vectors
+var rfcVectors = []RFCVector{
+	{rfcSecret20, SHA1, time.Unix(59, 0).UTC(), "94287082"},
+	{rfcSecret32, SHA256, time.Unix(59, 0).UTC(), "46119246"},
+	{rfcSecret64, SHA512, time.Unix(59, 0).UTC(), "90693936"},
+	{rfcSecret20, SHA1, time.Unix(1111111109, 0).UTC(), "07081804"},
+	{rfcSecret32, SHA256, time.Unix(1111111109, 0).UTC(), "68084774"},
+	{rfcSecret64, SHA512, time.Unix(1111111109, 0).UTC(), "25091201"},
+	{rfcSecret20, SHA1, time.Unix(1111111111, 0).UTC(), "14050471"},
+	{rfcSecret32, SHA256, time.Unix(1111111111, 0).UTC(), "67062674"},
+	{rfcSecret64, SHA512, time.Unix(1111111111, 0).UTC(), "99943326"},
+	{rfcSecret20, SHA1, time.Unix(1234567890, 0).UTC(), "89005924"},
+	{rfcSecret32, SHA256, time.Unix(1234567890, 0).UTC(), "91819424"},
+	{rfcSecret64, SHA512, time.Unix(1234567890, 0).UTC(), "93441116"},
+	{rfcSecret20, SHA1, time.Unix(2000000000, 0).UTC(), "69279037"},
+	{rfcSecret32, SHA256, time.Unix(2000000000, 0).UTC(), "90698825"},
+	{rfcSecret64, SHA512, time.Unix(2000000000, 0).UTC(), "38618901"},
+	{rfcSecret20, SHA1, time.Unix(20000000000, 0).UTC(), "65353130"},
+	{rfcSecret32, SHA256, time.Unix(20000000000, 0).UTC(), "77737706"},
+	{rfcSecret64, SHA512, time.Unix(20000000000, 0).UTC(), "47863826"},
+}
+
+// RFCVectors returns the RFC 6238 test vectors; see CheckRFCVector
+func RFCVectors() []RFCVector {
+	return append([]RFCVector(nil), rfcVectors...)
+}
+
+// CheckRFCVector reports whether the TOTP mode derives the vector code for
+// the vector secret, algorithm, and time
+func CheckRFCVector(v RFCVector) bool {
+
+	secret, ok := parseSecret(v.Secret)
+	if !ok {
+		return false
+	}
+
+	interval := 30 * time.Second
+	var pk PassKey
+	pk.Algorithm(v.Algorithm).Interval(&interval).TOTP(8)
+
+	return pk.encodeCode(pk.code(secret, v.Time, 0)) == v.Code
+}
@@ -0,0 +1,22 @@
+package passkey
+
+import "testing"
+
+func TestRFCVectors(t *testing.T) {
+
+	vectors := RFCVectors()
+	if len(vectors) == 0 {
+		t.Fatal("no vectors")
+	}
+
+	for _, v := range vectors {
+		if !CheckRFCVector(v) {
+			t.Errorf("%s %s: code %s not derived", v.Algorithm, v.Time.UTC().Format("2006-01-02T15:04:05"), v.Code)
+		}
+		wrong := v
+		wrong.Code = "00000000"
+		if CheckRFCVector(wrong) {
+			t.Errorf("%s %s: wrong code accepted", v.Algorithm, v.Time.UTC().Format("2006-01-02T15:04:05"))
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package passkey
+
+import (
+	"time"
+)
+
+/*
+
+	VECTORS
+	golden (secret, time, token) vectors so implementations in other
+	languages can confirm they match byte-for-byte; the scheme is
+
+	message  little-endian uint64 of the unix seconds of the reference
+	         time less one interval rounded to the nearest interval; the
+	         unix nanoseconds for an interval under one second
+	hash     HMAC of the message keyed by the binary secret
+	offset   ((hash[len(hash)-1] & 0xf) / 2) + 1
+	token    little-endian uint64 of hash[offset:offset+8]
+	wire     base32 of the little-endian token followed by the
+	         obfuscation bytes; 16 characters by default
+
+	Vector.Token is the current window token value at Vector.Time
+
+*/
+
+// Vector is a golden token vector for the current window at Time
+type Vector struct {
+	Secret    string        // base32 encoded secret
+	Algorithm Algorithm     // hmac hash
+	Interval  time.Duration // token interval
+	Time      time.Time     // reference time
+	Token     uint64        // current window token value
+}
+
+// vector secrets of 20, 32, and 64 bytes
+const (
+	secret20 = "PASSKEYXXBASE32XXSECRETXXEXAMPLE"
+	secret32 = "AAAQEAYEAUDAOCAJBIFQYDIOB4IBCEQTCQKRMFYYDENBWHA5DYPQ===="
+	secret64 = "777P37H37L47R57W6X2PH4XR6DX653PM5PVOT2HH43S6JY7C4HQN7XW53TN5VWOY27LNLVGT2LI5BT6OZXGMXSWJZDD4NROEYPBMDQA="
+)
+
+// vectors spanning the secret lengths, algorithms, and intervals
+var vectors = []Vector{
+	{secret20, SHA1, 15 * time.Second, time.Unix(0, 0).UTC(), 0xf794112676ed7f77},
+	{secret20, SHA1, 15 * time.Second, time.Unix(1111111109, 0).UTC(), 0x6bf34b39f151f05f},
+	{secret20, SHA1, 15 * time.Second, time.Unix(1234567890, 0).UTC(), 0x22ae3cfca81b5c20},
+	{secret20, SHA1, 15 * time.Second, time.Unix(2000000000, 0).UTC(), 0x954acb0c0b81db8f},
+	{secret20, SHA1, time.Minute, time.Unix(0, 0).UTC(), 0xc6b7a033f2dedc30},
+	{secret20, SHA1, time.Minute, time.Unix(1111111109, 0).UTC(), 0x2b6820965c1d5ae5},
+	{secret20, SHA1, time.Minute, time.Unix(1234567890, 0).UTC(), 0xd923bca971093350},
+	{secret20, SHA1, time.Minute, time.Unix(2000000000, 0).UTC(), 0x1e31dcbc3082e1a5},
+	{secret20, SHA1, time.Hour, time.Unix(0, 0).UTC(), 0x61202cb1f59d712a},
+	{secret20, SHA1, time.Hour, time.Unix(1111111109, 0).UTC(), 0xba7aa04b5f3e7980},
+	{secret20, SHA1, time.Hour, time.Unix(1234567890, 0).UTC(), 0xd54e1ac190f701a4},
+	{secret20, SHA1, time.Hour, time.Unix(2000000000, 0).UTC(), 0x9329ff7e6ff6d03e},
+	{secret32, SHA256, 15 * time.Second, time.Unix(0, 0).UTC(), 0x784cbb31d1e0186f},
+	{secret32, SHA256, 15 * time.Second, time.Unix(1111111109, 0).UTC(), 0x19a857f3474325f8},
+	{secret32, SHA256, 15 * time.Second, time.Unix(1234567890, 0).UTC(), 0xf68856253d8ebc56},
+	{secret32, SHA256, 15 * time.Second, time.Unix(2000000000, 0).UTC(), 0x133119f26d26b884},
+	{secret32, SHA256, time.Minute, time.Unix(0, 0).UTC(), 0x105595747bbc6aba},
+	{secret32, SHA256, time.Minute, time.Unix(1111111109, 0).UTC(), 0x83de3013864bf480},
+	{secret32, SHA256, time.Minute, time.Unix(1234567890, 0).UTC(), 0xf50ac954516a1820},
+	{secret32, SHA256, time.Minute, time.Unix(2000000000, 0).UTC(), 0x9805733310817ae3},
+	{secret32, SHA256, time.Hour, time.Unix(0, 0).UTC(), 0xfe3b640796036ffc},
+	{secret32, SHA256, time.Hour, time.Unix(1111111109, 0).UTC(), 0x6b6c1781adba7df6},
+	{secret32, SHA256, time.Hour, time.Unix(1234567890, 0).UTC(), 0x104f59a6df9e20bc},
+	{secret32, SHA256, time.Hour, time.Unix(2000000000, 0).UTC(), 0x437529ede7dbc1ae},
+	{secret64, SHA512, 15 * time.Second, time.Unix(0, 0).UTC(), 0x845d3814d9f101f3},
+	{secret64, SHA512, 15 * time.Second, time.Unix(1111111109, 0).UTC(), 0xb15866e86b467444},
+	{secret64, SHA512, 15 * time.Second, time.Unix(1234567890, 0).UTC(), 0xb54803ec97891312},
+	{secret64, SHA512, 15 * time.Second, time.Unix(2000000000, 0).UTC(), 0x0b3a2f153415eb78},
+	{secret64, SHA512, time.Minute, time.Unix(0, 0).UTC(), 0x505993ddb90470fd},
+	{secret64, SHA512, time.Minute, time.Unix(1111111109, 0).UTC(), 0x2c8f73c5f3e16a62},
+	{secret64, SHA512, time.Minute, time.Unix(1234567890, 0).UTC(), 0xf697160382f09272},
+	{secret64, SHA512, time.Minute, time.Unix(2000000000, 0).UTC(), 0xfca94be113a5b0c8},
+	{secret64, SHA512, time.Hour, time.Unix(0, 0).UTC(), 0x809447e6552f626d},
+	{secret64, SHA512, time.Hour, time.Unix(1111111109, 0).UTC(), 0xc5413431a5591468},
+	{secret64, SHA512, time.Hour, time.Unix(1234567890, 0).UTC(), 0x7774e7f6eb5467f6},
+	{secret64, SHA512, time.Hour, time.Unix(2000000000, 0).UTC(), 0xe27fca297dea5905},
+}
+
+// TestVectors returns the golden token vectors for cross-implementation
+// verification; see CheckVector
+func TestVectors() []Vector {
+	return append([]Vector(nil), vectors...)
+}
+
+// CheckVector reports whether this implementation derives the vector token
+// for the vector secret, algorithm, interval, and time
+func CheckVector(v Vector) bool {
+
+	secret, ok := parseSecret(v.Secret)
+	if !ok {
+		return false
+	}
+
+	var pk PassKey
+	pk.Algorithm(v.Algorithm).Interval(&v.Interval)
+
+	return pk.derive(secret, v.Time, 0) == v.Token
+}
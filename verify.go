@@ -0,0 +1,661 @@
+package passkey
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/*
+
+	VERIFY
+	core token verification decoupled from net/http so it can be
+	called from any server framework; IsValid extracts the header
+	and delegates here
+
+*/
+
+// Window is the interval of a matched token relative to the current interval
+type Window int
+
+const (
+	Previous Window = -1 // token for the previous interval
+	Current  Window = 0  // token for the current interval
+	Next     Window = 1  // token for the next interval
+)
+
+// String returns the window name
+func (w Window) String() string {
+	switch w {
+	case Previous:
+		return "previous"
+	case Current:
+		return "current"
+	case Next:
+		return "next"
+	}
+	return fmt.Sprintf("window%+d", int(w))
+}
+
+var (
+	// ErrMalformed is returned when a token can not be decoded
+	ErrMalformed = errors.New("passkey: malformed token")
+	// ErrUnauthorized is returned when a token matches no valid window
+	ErrUnauthorized = errors.New("passkey: unauthorized token")
+	// ErrWrongLength is returned when a decoded token has the wrong length
+	ErrWrongLength = errors.New("passkey: wrong token length")
+	// ErrReserved is returned in strict mode when reserved bits are set
+	ErrReserved = errors.New("passkey: reserved token bits set")
+	// ErrVersion is returned when a structured header has an unknown version
+	ErrVersion = errors.New("passkey: unknown token version")
+	// ErrStale is returned when a stamped token carries an implausible time
+	ErrStale = errors.New("passkey: stale token")
+	// ErrInsecure is returned by RequireTLS for a request not received over TLS
+	ErrInsecure = errors.New("passkey: request not received over TLS")
+	// ErrExpired is returned by ExpiredStatus for a recently valid token
+	ErrExpired = errors.New("passkey: expired token")
+	// ErrLeaked is returned by RejectLeaked for a token found in the URL or Referer
+	ErrLeaked = errors.New("passkey: token leaked into the URL or referer")
+)
+
+// reserved bits of the first obfuscation byte; always zero from clients
+const reserved = 0x80
+
+// status returns the http status code for a verification error
+func status(err error) int {
+	switch err {
+	case ErrMalformed, ErrWrongLength, ErrReserved, ErrVersion, ErrInsecure:
+		return http.StatusBadRequest // 400
+	case ErrOnce:
+		return http.StatusTooManyRequests // 429
+	case ErrLeaked:
+		return http.StatusForbidden // 403
+	}
+	return http.StatusUnauthorized // 401
+}
+
+// StatusExpired is the default ExpiredStatus code; 419 Page Expired
+const StatusExpired = 419
+
+// ExpiredStatus rejects a token of the configured secret that was valid in
+// the window just before the oldest accepted window, or in a past window
+// excluded by Windows, with ErrExpired and the status code so a client can
+// refresh and retry rather than treat it as a wrong token; combine with
+// PastSkew(0) to reject the previous window as expired; a failed token
+// costs one more HMAC per expired window; codes outside 400 through 499
+// use StatusExpired with a warning
+//
+//	pass 0 to disable; default
+func (pk *Server) ExpiredStatus(code int) *Server {
+
+	if code != 0 && (code < 400 || code > 499) {
+		warn("expired status %d is not a client error; using %d", code, StatusExpired)
+		code = StatusExpired
+	}
+	pk.expired = code
+
+	return pk
+}
+
+// status returns the http status code for a verification error with the
+// ExpiredStatus code for ErrExpired
+func (pk *Server) status(err error) int {
+	if err == ErrExpired && pk.expired != 0 {
+		return pk.expired
+	}
+	return status(err)
+}
+
+// expiredAt reports whether the token value was valid for the configured
+// secret in an expired window relative to the time t; see ExpiredStatus
+func (pk *Server) expiredAt(v uint64, t time.Time, scope string) bool {
+
+	g := pk.gen()
+	if g.disabled.Load() {
+		return false
+	}
+
+	secret := g.loadSecret()
+	past, _ := pk.skew()
+	for w := Window(-past - 1); w < Current; w++ {
+		if !pk.accepts(w) && v == pk.deriveScope(secret, t, int(w), scope) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// token returns the token from the header value; in structured header mode
+// the version prefix is validated and removed
+func (pk *Server) token(value string) (string, error) {
+
+	if !pk.versioned {
+		return value, nil
+	}
+
+	v, token, ok := strings.Cut(value, " ")
+	if !ok {
+		return "", ErrMalformed
+	}
+	if v != version {
+		return "", ErrVersion
+	}
+
+	return token, nil
+}
+
+// maxTokens is the maximum number of comma-joined tokens checked per request
+const maxTokens = 4
+
+// verifyList validates a comma-joined list of tokens and returns the window
+// of the first valid token; at most maxTokens are checked to bound the work;
+// tokens are bound to the request scope when it is not empty and checked
+// against the context secret alone when it is not nil; see WithSecret
+func (pk *Server) verifyList(list, scope string, secret []byte) (Window, error) {
+
+	var err error
+	for i := 0; i < maxTokens; i++ {
+		token, rest, more := strings.Cut(list, ",")
+		var w Window
+		if w, err = pk.verifyScope(strings.TrimSpace(token), scope, secret); err == nil {
+			return w, nil
+		}
+		if !more {
+			break
+		}
+		list = rest
+	}
+
+	return 0, err
+}
+
+// Verify validates the base32 token against the valid token set and returns
+// the matched window; ErrMalformed or ErrUnauthorized on failure
+func (pk *Server) Verify(token string) (Window, error) {
+	return pk.VerifyBytes([]byte(token))
+}
+
+// VerifyBytes validates the base32 token held in a byte slice against the
+// valid token set and returns the matched window; for use with non-net/http
+// servers that expose header values as bytes
+func (pk *Server) VerifyBytes(token []byte) (Window, error) {
+
+	v, err := pk.decode(token)
+	if err != nil {
+		return 0, err
+	}
+
+	return pk.verify(v)
+}
+
+// VerifyRaw validates a token already decoded from base32, the 8-byte token
+// value followed by the obfuscation bytes, for binary transports that carry
+// the decoded form and returns the matched window; the same length, strict,
+// and stamp checks apply as to the decoded string token, which Verify and
+// the middleware run after decoding; native format only and not request
+// bound; see VerifyBinary for the bare 8-byte value
+func (pk *Server) VerifyRaw(b []byte) (Window, error) {
+
+	if err := pk.check(b); err != nil {
+		return 0, err
+	}
+
+	return pk.verify(binary.LittleEndian.Uint64(b[:8]))
+}
+
+// VerifyExtract validates the token and returns the matched window with a
+// copy of the decoded bytes beyond the 8-byte token value; the extra bytes
+// are outside the HMAC so any client can set them and they must not be
+// trusted for identity
+func (pk *Server) VerifyExtract(token string) (Window, []byte, error) {
+
+	var buf [40]byte
+	b, err := pk.decodeTo(buf[:], []byte(token))
+	if err != nil {
+		return 0, nil, err
+	}
+	v, err := counter(b)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	w, err := pk.verify(v)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return w, append([]byte(nil), b[8:]...), nil
+}
+
+// VerifyBinary validates the raw 8-byte token from Client.TokenBinary for
+// bandwidth constrained links and returns the matched window; binary mode
+// has no obfuscation bytes so identical tokens repeat on the wire within
+// an interval; pair it with a separate nonce when replay protection matters
+func (pk *Server) VerifyBinary(b [8]byte) (Window, error) {
+	return pk.verify(binary.LittleEndian.Uint64(b[:]))
+}
+
+// VerifyUint64 validates the integer token value from Client.TokenUint64
+// and returns the matched window
+func (pk *Server) VerifyUint64(v uint64) (Window, error) {
+	return pk.verify(v)
+}
+
+// verify matches the token value and returns the window
+func (pk *Server) verify(v uint64) (Window, error) {
+
+	w, ok := pk.match(v)
+	if !ok {
+		if pk.expired != 0 && pk.expiredAt(v, pk.now(), "") {
+			return 0, ErrExpired
+		}
+		pk.presented(v, pk.now(), "")
+		return 0, ErrUnauthorized
+	}
+	if w != Current && pk.onSkew != nil {
+		pk.onSkew(w)
+	}
+
+	return w, nil
+}
+
+// verifyScope validates the token bound to the request scope or of the
+// context secret; the token set depends on the request so it is derived per
+// request rather than read from the generator; an empty scope without a
+// context secret is Verify
+func (pk *Server) verifyScope(token, scope string, secret []byte) (Window, error) {
+
+	if len(scope) == 0 && secret == nil {
+		return pk.Verify(token)
+	}
+
+	v, err := pk.decode([]byte(token))
+	if err != nil {
+		return 0, err
+	}
+
+	t := pk.now()
+	if secret != nil {
+		w, ok := pk.matchSecret(v, t, scope, secret)
+		if !ok {
+			return 0, ErrUnauthorized
+		}
+		return w, nil
+	}
+
+	w, ok := pk.matchAt(v, t, scope)
+	if !ok {
+		if pk.expired != 0 && pk.expiredAt(v, t, scope) {
+			return 0, ErrExpired
+		}
+		pk.presented(v, t, scope)
+		return 0, ErrUnauthorized
+	}
+	if w != Current && pk.onSkew != nil {
+		pk.onSkew(w)
+	}
+
+	return w, nil
+}
+
+// VerifyAt reports whether the token was valid at the time t; the valid
+// token set is derived relative to t rather than the running generator so
+// it can be used for testing and post-hoc audit of logged requests
+func (pk *Server) VerifyAt(token string, t time.Time) bool {
+
+	v, err := pk.decode([]byte(token))
+	if err != nil {
+		return false
+	}
+
+	_, ok := pk.matchAt(v, t, "")
+	return ok
+}
+
+// VerifyAny validates the token at the time t against each secret for a
+// stateless multi-tenant verifier and returns the index of the matching
+// secret and the window; every window of every secret is computed and
+// compared in constant time so timing does not leak which secret matched;
+// opts configure the interval, algorithm, skew, and token settings
+//
+//	i, w, err := passkey.VerifyAny(tenants, token, time.Now(), passkey.WithInterval(30*time.Second))
+func VerifyAny(secrets [][20]byte, token string, t time.Time, opts ...Option) (int, Window, error) {
+
+	pk := new(Server)
+	for _, opt := range opts {
+		opt(pk)
+	}
+	if pk.interval == 0 {
+		pk.Interval(nil)
+	}
+
+	v, err := pk.decode([]byte(token))
+	if err != nil {
+		return -1, 0, err
+	}
+
+	var want, got [8]byte
+	binary.LittleEndian.PutUint64(want[:], v)
+
+	index, window, found := -1, 0, 0
+	past, future := pk.skew()
+	for i := range secrets {
+		for w := Window(-past); w <= Window(future); w++ {
+			binary.LittleEndian.PutUint64(got[:], pk.derive(secrets[i][:], t, int(w)))
+			ok := subtle.ConstantTimeCompare(got[:], want[:])
+			if !pk.accepts(w) {
+				ok = 0
+			}
+			first := ok &^ found
+			index = subtle.ConstantTimeSelect(first, i, index)
+			window = subtle.ConstantTimeSelect(first, int(w), window)
+			found |= ok
+		}
+	}
+
+	if found == 0 {
+		return -1, 0, ErrUnauthorized
+	}
+
+	return index, Window(window), nil
+}
+
+// ValidUntil returns the time the token falls out of the accepted windows
+// so a client caching a token knows when to refresh; returns the decode
+// error or ErrUnauthorized when the token matches no accepted window and
+// ErrInterval in Counter mode where windows have no end time
+func (pk *Server) ValidUntil(token string) (time.Time, error) {
+
+	v, err := pk.decode([]byte(token))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	w, ok := pk.match(v)
+	if !ok {
+		return time.Time{}, ErrUnauthorized
+	}
+	if pk.counter != nil || pk.interval <= 0 {
+		return time.Time{}, ErrInterval
+	}
+
+	return pk.now().Add(pk.expires(w)), nil
+}
+
+// decode the token after checking the token length and return the 8-byte
+// token value; the random obfuscation bits are ignored
+func (pk *Server) decode(token []byte) (uint64, error) {
+
+	if pk.digits > 0 {
+		return pk.parseCode(token) // numeric code; see TOTP
+	}
+
+	var buf [40]byte
+	b, err := pk.decodeTo(buf[:], token)
+	if err != nil {
+		return 0, err
+	}
+
+	return counter(b)
+}
+
+// decodeTo decodes the token into buf when it fits and returns the decoded
+// token; the 8-byte token value followed by the obfuscation bytes
+func (pk *Server) decodeTo(buf, token []byte) ([]byte, error) {
+
+	if len(token) > pk.maxTokenLen() {
+		return nil, ErrMalformed
+	}
+	if pk.codec != nil {
+		b, err := pk.codec.Decode(string(token))
+		if err != nil {
+			return nil, ErrMalformed
+		}
+		if err := pk.check(b); err != nil {
+			return nil, err
+		}
+		return b, nil
+	}
+
+	// tokens lowercased by an intermediary
+	var fold [64]byte
+	if pk.caseless {
+		token = upper(token, fold[:0])
+	}
+
+	// fast path for the standard 16-character token
+	size := pk.size()
+	if len(token) == 16 && size == 10 {
+		if b, ok := decode16(token); ok {
+			if err := pk.check(b[:]); err != nil {
+				return nil, err
+			}
+			return append(buf[:0], b[:]...), nil
+		}
+	}
+
+	// accept the padded and unpadded forms
+	if pk.noPadding {
+		token = bytes.TrimRight(token, "=")
+	}
+	enc := pk.encoding()
+
+	// decode into buf when the token fits
+	b := buf
+	if n := enc.DecodedLen(len(token)); n > len(b) {
+		b = make([]byte, n)
+	}
+
+	n, err := enc.Decode(b, token)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	if err := pk.check(b[:n]); err != nil {
+		return nil, err
+	}
+
+	return b[:n], nil
+}
+
+// check validates the decoded token length for the configured obfuscation
+// and the reserved bits and stamp of the obfuscation bytes
+func (pk *Server) check(b []byte) error {
+
+	n := len(b)
+	if n != pk.size() {
+		return ErrWrongLength
+	}
+	if pk.strict && n > 8 && b[8]&reserved != 0 {
+		return ErrReserved
+	}
+	if pk.stamp && n >= 10 && !pk.checkStamp(b[8:n]) {
+		return ErrStale
+	}
+
+	return nil
+}
+
+// alphabet maps a base32 character to its 5-bit value or 0xFF when invalid
+var alphabet = func() (a [256]byte) {
+	for i := range a {
+		a[i] = 0xFF
+	}
+	for i, c := range "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567" {
+		a[c] = byte(i)
+	}
+	return
+}()
+
+// upper returns the token with lowercase ASCII letters uppercased; the
+// token is returned as is when it has none and is otherwise copied into buf
+// so the caller token is never modified
+func upper(token, buf []byte) []byte {
+
+	i := bytes.IndexFunc(token, func(r rune) bool { return 'a' <= r && r <= 'z' })
+	if i < 0 {
+		return token
+	}
+
+	b := append(buf, token...)
+	for ; i < len(b); i++ {
+		if 'a' <= b[i] && b[i] <= 'z' {
+			b[i] -= 'a' - 'A'
+		}
+	}
+
+	return b
+}
+
+// decode16 decodes a 16-character base32 token into a 10-byte stack array;
+// returns false for any character outside the alphabet, including padding,
+// so the caller falls back to the general decoder with identical results
+func decode16(token []byte) (b [10]byte, ok bool) {
+
+	var v [16]byte
+	for i, c := range token[:16] {
+		if v[i] = alphabet[c]; v[i] == 0xFF {
+			return b, false
+		}
+	}
+
+	// two 8-character blocks of 40-bits each decode to 5-bytes
+	for i := 0; i < 2; i++ {
+		s, d := v[i*8:], b[i*5:]
+		d[0] = s[0]<<3 | s[1]>>2
+		d[1] = s[1]<<6 | s[2]<<1 | s[3]>>4
+		d[2] = s[3]<<4 | s[4]>>1
+		d[3] = s[4]<<7 | s[5]<<2 | s[6]>>3
+		d[4] = s[6]<<5 | s[7]
+	}
+
+	return b, true
+}
+
+// checkStamp reports whether the 15-bit seconds stamp in the obfuscation
+// bytes is within the accepted windows of the server clock; the stamp wraps
+// every 9 hours so the check is skipped when the accepted span approaches
+// the wrap and can not be judged
+func (pk *Server) checkStamp(b []byte) bool {
+
+	past, future := pk.skew()
+	before := int64(past+1) * int64(pk.interval/time.Second)
+	after := int64(future+1) * int64(pk.interval/time.Second)
+	if pk.interval < time.Second || before+after >= stampMask/2 {
+		return true
+	}
+
+	s := uint16(b[0])<<8 | uint16(b[1])
+	d := int64((stamp(pk.now()) - s) & stampMask)
+	if d > stampMask/2 {
+		d -= stampMask + 1 // stamp ahead of the server clock
+	}
+
+	return -after <= d && d <= before
+}
+
+// counter returns the 8-byte token value from the decoded token; bounds
+// checked so a short token is an error rather than a panic
+func counter(b []byte) (uint64, error) {
+	if len(b) < 8 {
+		return 0, ErrWrongLength
+	}
+	return binary.LittleEndian.Uint64(b[:8]), nil
+}
+
+// match returns the window of the token value in the valid token set of the
+// generator or of any additional rotation secret; every accepted window is
+// compared in constant time so timing does not leak which window matched
+func (pk *Server) match(v uint64) (Window, bool) {
+
+	g := pk.gen()
+	if g.disabled.Load() {
+		return pk.matchKeys(v, pk.now(), "") // primary secret disabled
+	}
+
+	var m matcher
+	for i, w := range [3]Window{Current, Next, Previous} {
+		m.add(w, v, g.cnp[i].Load(), pk.accepts(w))
+	}
+
+	// skew windows beyond the generator set are read from the past ring or
+	// derived on demand
+	if past, future := pk.skew(); past > 1 || future > 1 {
+		secret, t := g.loadSecret(), pk.now()
+		for w := Window(-past); w <= Window(future); w++ {
+			if w >= Previous && w <= Next || !pk.accepts(w) {
+				continue
+			}
+			r, ok := g.ringAt(w)
+			if !ok {
+				r = pk.derive(secret, t, int(w))
+			}
+			m.add(w, v, r, true)
+		}
+	}
+
+	if w, ok := m.result(); ok {
+		return w, true
+	}
+
+	return pk.matchKeys(v, pk.now(), "")
+}
+
+// matcher selects the first matching window of a token value without a
+// data dependent branch
+type matcher struct {
+	window int
+	found  int
+}
+
+// add compares the token value v with the window value r in constant time;
+// a window that is not accepted never matches
+func (m *matcher) add(w Window, v, r uint64, accepted bool) {
+	x := v ^ r
+	ok := subtle.ConstantTimeEq(int32(uint32(x>>32)|uint32(x)), 0)
+	if !accepted {
+		ok = 0
+	}
+	first := ok &^ m.found
+	m.window = subtle.ConstantTimeSelect(first, int(w), m.window)
+	m.found |= ok
+}
+
+// result returns the first matching window
+func (m *matcher) result() (Window, bool) {
+	return Window(m.window), m.found == 1
+}
+
+// matchAt returns the window of the token value in the valid token set
+// derived relative to the time t for the secret or any rotation secret
+func (pk *Server) matchAt(v uint64, t time.Time, scope string) (Window, bool) {
+
+	if g := pk.gen(); !g.disabled.Load() {
+		if w, ok := pk.matchSecret(v, t, scope, g.loadSecret()); ok {
+			return w, true
+		}
+	}
+
+	return pk.matchKeys(v, t, scope)
+}
+
+// matchSecret returns the window of the token value derived relative to the
+// time t for the secret alone; every accepted window is compared in
+// constant time
+func (pk *Server) matchSecret(v uint64, t time.Time, scope string, secret []byte) (Window, bool) {
+
+	var m matcher
+	past, future := pk.skew()
+	for w := Window(-past); w <= Window(future); w++ {
+		if pk.accepts(w) {
+			m.add(w, v, pk.deriveScope(secret, t, int(w), scope), true)
+		}
+	}
+
+	return m.result()
+}
@@ -0,0 +1,359 @@
+package passkey
+
+import (
+	"context"
+	"encoding/base32"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWrongLength(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// a longer limit so an 11-byte token reaches the length check
+	pk := NewServer(ctx, rfcSecret20).MaxTokenLen(64)
+	for _, n := range []int{0, 7, 8, 9, 11} {
+		b := make([]byte, n)
+		if n < 8 {
+			if _, err := counter(b); err != ErrWrongLength {
+				t.Errorf("counter %d bytes: %v", n, err)
+			}
+		}
+		if err := pk.check(b); err != ErrWrongLength {
+			t.Errorf("check %d bytes: %v", n, err)
+		}
+		token := pk.encoding().EncodeToString(b)
+		if _, err := pk.Verify(token); err != ErrWrongLength {
+			t.Errorf("verify %d bytes %q: %v", n, token, err)
+		}
+		if _, err := pk.VerifyRaw(b); err != ErrWrongLength {
+			t.Errorf("verify raw %d bytes: %v", n, err)
+		}
+	}
+}
+
+// serve returns the status of the request through the IsValid middleware
+func serve(pk *Server, r *http.Request) int {
+	w := httptest.NewRecorder()
+	pk.IsValid(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestRepeatedHeader(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pk := NewServer(ctx, rfcSecret20)
+	client := NewClient(ctx, rfcSecret20)
+	other := NewClient(ctx, rfcSecret32)
+
+	for _, tc := range []struct {
+		values []string
+		code   int
+	}{
+		{[]string{other.Token(), client.Token()}, http.StatusOK},
+		{[]string{"malformed!", client.Token()}, http.StatusOK},
+		{[]string{client.Token(), other.Token()}, http.StatusOK},
+		{[]string{other.Token(), other.Token()}, http.StatusUnauthorized},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header["Token"] = tc.values
+		if code := serve(pk, r); code != tc.code {
+			t.Errorf("values %v: status %d want %d", tc.values, code, tc.code)
+		}
+	}
+}
+
+func TestCaseInsensitive(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pk := NewServer(ctx, rfcSecret20)
+	client := NewClient(ctx, rfcSecret20)
+	token := client.Token()
+
+	mixed := []byte(token)
+	for i := 0; i < len(mixed); i += 2 {
+		if 'A' <= mixed[i] && mixed[i] <= 'Z' {
+			mixed[i] += 'a' - 'A'
+		}
+	}
+
+	for _, caseless := range []bool{false, true} {
+		pk.CaseInsensitive(caseless)
+		for _, tc := range []string{token, strings.ToLower(token), string(mixed)} {
+			_, err := pk.Verify(tc)
+			switch {
+			case tc == token && err != nil:
+				t.Errorf("caseless %v upper: %v", caseless, err)
+			case tc != token && caseless && err != nil:
+				t.Errorf("caseless %v %q: %v", caseless, tc, err)
+			case tc != token && !caseless && err != ErrMalformed:
+				t.Errorf("caseless %v %q: got %v want ErrMalformed", caseless, tc, err)
+			}
+		}
+	}
+
+	// the caller token is never modified
+	lower := []byte(strings.ToLower(token))
+	if _, err := pk.VerifyBytes(lower); err != nil || string(lower) != strings.ToLower(token) {
+		t.Errorf("lowercase bytes %q: %v", lower, err)
+	}
+}
+
+func TestDebugWindow(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pk := NewServer(ctx, rfcSecret20)
+	client := NewClient(ctx, rfcSecret20)
+	other := NewClient(ctx, rfcSecret32)
+	handler := pk.IsValid(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, on := range []bool{false, true} {
+		pk.DebugWindow(on)
+		for _, tc := range []struct {
+			token string
+			code  int
+		}{
+			{client.Token(), http.StatusOK},
+			{other.Token(), http.StatusUnauthorized},
+			{"malformed!", http.StatusBadRequest},
+		} {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Token", tc.token)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tc.code {
+				t.Fatalf("status %d want %d", w.Code, tc.code)
+			}
+
+			value := w.Header().Get(DebugWindowHeader)
+			index := strconv.FormatUint(pk.CurrentIndex(), 10)
+			switch {
+			case on && tc.code == http.StatusUnauthorized && !strings.HasPrefix(value, index+" "):
+				t.Errorf("status %d: header %q want index %s", tc.code, value, index)
+			case (!on || tc.code != http.StatusUnauthorized) && value != "":
+				t.Errorf("debug %v status %d: header %q", on, tc.code, value)
+			}
+		}
+	}
+}
+
+func TestTrustedCIDRs(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pk := NewServer(ctx, rfcSecret20).TrustedCIDRs("10.0.0.0/8")
+
+	for _, tc := range []struct {
+		remote string
+		xff    string
+		trust  bool
+		code   int
+	}{
+		{"10.1.2.3:1234", "", false, http.StatusOK},
+		{"[::ffff:10.1.2.3]:1234", "", false, http.StatusOK},
+		{"192.0.2.1:1234", "", false, http.StatusBadRequest},
+		{"192.0.2.1:1234", "10.1.2.3", false, http.StatusBadRequest},
+		{"192.0.2.1:1234", "192.0.2.9, 10.1.2.3", true, http.StatusOK},
+		{"192.0.2.1:1234", "10.1.2.3, 192.0.2.9", true, http.StatusBadRequest},
+		{"10.1.2.3:1234", "", true, http.StatusOK},
+	} {
+		pk.TrustForwardedFor(tc.trust)
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tc.remote
+		if len(tc.xff) > 0 {
+			r.Header.Set("X-Forwarded-For", tc.xff)
+		}
+		if code := serve(pk, r); code != tc.code {
+			t.Errorf("remote %s xff %q trust %v: status %d want %d", tc.remote, tc.xff, tc.trust, code, tc.code)
+		}
+	}
+}
+
+func TestHeaderKeysEmpty(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pk := NewServer(ctx, rfcSecret20).SetHeaderKeys("token", "x-api-passkey")
+	client := NewClient(ctx, rfcSecret20)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header["Token"] = []string{""}
+	r.Header.Set("X-Api-Passkey", client.Token())
+	if code := serve(pk, r); code != http.StatusOK {
+		t.Errorf("empty token header: status %d want %d", code, http.StatusOK)
+	}
+}
+
+func TestRejectLeakedSources(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := NewClient(ctx, rfcSecret20)
+	token := client.Token()
+
+	headers := NewServer(ctx, rfcSecret20).RejectLeaked(true)
+	sources := NewServer(ctx, rfcSecret20).RejectLeaked(true).
+		Sources(HeaderSource("token"), QuerySource("token"))
+
+	for _, tc := range []struct {
+		name   string
+		pk     *Server
+		header string
+		query  string
+		code   int
+	}{
+		{"header keys query leak", headers, token, "?token=" + token, http.StatusForbidden},
+		{"header source query leak", sources, token, "?token=" + token, http.StatusForbidden},
+		{"header source other query", sources, token, "?page=2", http.StatusOK},
+		{"query source", sources, "", "?token=" + token, http.StatusOK},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/"+tc.query, nil)
+		if len(tc.header) > 0 {
+			r.Header.Set("Token", tc.header)
+		}
+		if code := serve(tc.pk, r); code != tc.code {
+			t.Errorf("%s: status %d want %d", tc.name, code, tc.code)
+		}
+	}
+}
+
+func TestBindRequest(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pk := NewServer(ctx, rfcSecret20)
+	pk.BindRequest(true)
+	client := NewClient(ctx, rfcSecret20)
+	client.BindRequest(true)
+
+	bound := httptest.NewRequest(http.MethodPost, "/orders", nil)
+	client.SetHeader(bound)
+	token := bound.Header.Get(client.HeaderKey())
+
+	for _, tc := range []struct {
+		method, path string
+		code         int
+	}{
+		{http.MethodPost, "/orders", http.StatusOK},
+		{http.MethodGet, "/orders", http.StatusUnauthorized},
+		{http.MethodPost, "/refunds", http.StatusUnauthorized},
+	} {
+		r := httptest.NewRequest(tc.method, tc.path, nil)
+		r.Header.Set(client.HeaderKey(), token)
+		if code := serve(pk, r); code != tc.code {
+			t.Errorf("%s %s: status %d want %d", tc.method, tc.path, code, tc.code)
+		}
+	}
+}
+
+func TestVerifyAnyIndex(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tenants := make([][20]byte, 4)
+	for i := range tenants {
+		for j := range tenants[i] {
+			tenants[i][j] = byte(i*20 + j + 1)
+		}
+	}
+
+	now := time.Now()
+	for i := range tenants {
+		client := NewClient(ctx, base32.StdEncoding.EncodeToString(tenants[i][:]))
+		index, _, err := VerifyAny(tenants, client.Token(), now)
+		if err != nil || index != i {
+			t.Errorf("tenant %d: index %d err %v", i, index, err)
+		}
+	}
+
+	other := NewClient(ctx, rfcSecret20)
+	if index, _, err := VerifyAny(tenants, other.Token(), now); err == nil || index != -1 {
+		t.Errorf("unknown secret: index %d err %v", index, err)
+	}
+}
+
+func TestWithSecret(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var tenant [20]byte
+	copy(tenant[:], "tenant secret 012345")
+
+	pk := NewServer(ctx, rfcSecret20)
+	configured := NewClient(ctx, rfcSecret20)
+	override := NewClient(ctx, base32.StdEncoding.EncodeToString(tenant[:]))
+
+	for _, tc := range []struct {
+		name   string
+		secret bool
+		token  string
+		code   int
+	}{
+		{"context secret", true, override.Token(), http.StatusOK},
+		{"configured token with context secret", true, configured.Token(), http.StatusUnauthorized},
+		{"context token without context secret", false, override.Token(), http.StatusUnauthorized},
+		{"configured token", false, configured.Token(), http.StatusOK},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tc.secret {
+			r = r.WithContext(WithSecret(r.Context(), tenant))
+		}
+		r.Header.Set("Token", tc.token)
+		if code := serve(pk, r); code != tc.code {
+			t.Errorf("%s: status %d want %d", tc.name, code, tc.code)
+		}
+	}
+}
+
+func TestDryRun(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	other := NewClient(ctx, rfcSecret32)
+
+	for _, tc := range []struct {
+		dryRun  bool
+		called  bool
+		code    int
+		outcome string
+	}{
+		{true, true, http.StatusOK, "would-reject"},
+		{false, false, http.StatusUnauthorized, "rejected"},
+	} {
+		var events []AuditEvent
+		pk := NewServer(ctx, rfcSecret20).DryRun(tc.dryRun).Audit(func(e AuditEvent) { events = append(events, e) })
+
+		var called bool
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true })
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Token", other.Token())
+		w := httptest.NewRecorder()
+		pk.IsValid(next).ServeHTTP(w, r)
+
+		if called != tc.called || w.Code != tc.code {
+			t.Errorf("dry run %v: called %v status %d want %v %d", tc.dryRun, called, w.Code, tc.called, tc.code)
+		}
+		if len(events) != 1 || events[0].Outcome != tc.outcome {
+			t.Errorf("dry run %v: events %+v want one %s", tc.dryRun, events, tc.outcome)
+		}
+	}
+}